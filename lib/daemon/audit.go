@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decisions recorded in the audit log
+const (
+	auditGranted	= "granted"
+	auditDenied	= "denied"
+	auditRequested	= "requested"
+	auditRevoked	= "revoked"
+)
+
+type auditEntry struct {
+	Time		time.Time	`json:"time"`
+	AppID		string		`json:"appID"`
+	InstanceID	string		`json:"instanceID,omitempty"`
+	// One of expose, forward, document, share, devices or network
+	Event		string		`json:"event"`
+	Decision	string		`json:"decision"`
+	Path		string		`json:"path,omitempty"`
+	Detail		string		`json:"detail,omitempty"`
+}
+
+var auditLock sync.Mutex
+
+// Lives outside of the state directory, which the sandbox can write to
+func auditLogPath(appID string) string {
+	return filepath.Join(xdgDir.stateDir, "portable", "audit", appID + ".jsonl")
+}
+
+// Appends a grant decision to the audit log of the application
+func audit(config Config, event string, decision string, path string, detail string) {
+	entry := auditEntry{
+		Time:		time.Now(),
+		AppID:		config.Metadata.AppID,
+		InstanceID:	runtimeInfo.instanceID,
+		Event:		event,
+		Decision:	decision,
+		Path:		path,
+		Detail:		detail,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		pecho("warn", "Could not encode audit entry:", err)
+		return
+	}
+	// Ephemeral instances and profiles share the log of the installed application
+	logPath := auditLogPath(installedAppID(config))
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	err = os.MkdirAll(filepath.Dir(logPath), 0700)
+	if err != nil {
+		pecho("warn", "Could not create audit directory:", err)
+		return
+	}
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		pecho("warn", "Could not open audit log:", err)
+		return
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		pecho("warn", "Could not write audit log:", err)
+	}
+}
+
+// Records permissions the sandbox starts with. Devices are taken from the layout,
+// as requested classes may be unavailable or pull in further nodes
+func auditStartup(config Config) {
+	if len(runtimeInfo.deviceNodes) > 0 {
+		audit(config, "devices", auditGranted, "", strings.Join(runtimeInfo.deviceNodes, ","))
+	}
+	switch {
+		case ! config.Network.Enable:
+			audit(config, "network", auditDenied, "", "network disabled")
+		case config.Network.Filter && len(config.Network.FilterDest) > 0:
+			audit(config, "network", auditGranted, "", "filtered: " + strings.Join(config.Network.FilterDest, ","))
+		default:
+			audit(config, "network", auditGranted, "", "unfiltered")
+	}
+}
+
+// Handles --actions audit-log
+func printAuditLog(config Config) {
+	file, err := os.Open(auditLogPath(installedAppID(config)))
+	if os.IsNotExist(err) {
+		pecho("info", "No audit log recorded for " + installedAppID(config))
+		return
+	} else if err != nil {
+		pecho("warn", "Could not open audit log:", err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			pecho("warn", "Skipping malformed audit entry:", err)
+			continue
+		}
+		line := entry.Time.Format(time.DateTime) + "	" + entry.Event + "	" + entry.Decision
+		if entry.AppID != installedAppID(config) {
+			line = line + "	[" + entry.AppID + "]"
+		}
+		if len(entry.Path) > 0 {
+			line = line + "	" + entry.Path
+		}
+		if len(entry.Detail) > 0 {
+			line = line + "	(" + entry.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+	if err := scanner.Err(); err != nil {
+		pecho("warn", "Could not read audit log:", err)
+	}
+}
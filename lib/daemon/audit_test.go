@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestAuditAppend(t *testing.T) {
+	oldStateDir := xdgDir.stateDir
+	xdgDir.stateDir = t.TempDir()
+	defer func () {
+		xdgDir.stateDir = oldStateDir
+	} ()
+
+	var config Config
+	config.Metadata.AppID = "org.example.App"
+	audit(config, "expose", auditGranted, "/home/user/file", "/run/file")
+	audit(config, "network", auditRevoked, "", "cut off at runtime")
+
+	file, err := os.Open(auditLogPath(config.Metadata.AppID))
+	if err != nil {
+		t.Fatal("Could not open audit log:", err)
+	}
+	defer file.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatal("Malformed audit entry:", err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatal("Expected 2 entries, got:", entries)
+	}
+	if entries[0].Event != "expose" || entries[0].Path != "/home/user/file" || entries[1].Decision != auditRevoked {
+		t.Fatal("Unexpected audit entries:", entries)
+	}
+}
+
+func TestAuditEphemeral(t *testing.T) {
+	oldStateDir := xdgDir.stateDir
+	xdgDir.stateDir = t.TempDir()
+	defer func () {
+		xdgDir.stateDir = oldStateDir
+	} ()
+
+	var config Config
+	config.Metadata.AppID = "org.example.App"
+	applyEphemeral(&config, []string{"--ephemeral"})
+	audit(config, "expose", auditGranted, "/home/user/file", "/run/file")
+
+	if _, err := os.Stat(auditLogPath(config.Metadata.AppID)); ! os.IsNotExist(err) {
+		t.Error("Ephemeral instance left its own audit log:", err)
+	}
+	content, err := os.ReadFile(auditLogPath("org.example.App"))
+	if err != nil {
+		t.Fatal("Could not read audit log of the installed application:", err)
+	}
+	var entry auditEntry
+	if err := json.Unmarshal(content, &entry); err != nil || entry.AppID != "org.example.App.Ephemeral" {
+		t.Error("Unexpected audit entry:", string(content), err)
+	}
+}
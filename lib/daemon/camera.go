@@ -1,11 +1,7 @@
 package main
 
-import (
-	"slices"
-)
-
-func GenerateCameraBindArgs() ([]string, error) {
-	var res []string
+func GenerateCameraBindArgs() ([]Mount, error) {
+	var res []Mount
 
 	devs, err := enumerateDevices("video4linux")
 	if err != nil {
@@ -16,33 +12,15 @@ func GenerateCameraBindArgs() ([]string, error) {
 
 		devPath := dev.Devnode()
 		sysPath := dev.Syspath()
+		// Duplicated devlinks are removed by mountBuilder
 		for k := range dev.Devlinks() {
-			if slices.Contains(res, k) {
-				pecho(
-					"warn",
-					"Surplus devlink while binding cameras:", k,
-				)
-				continue
-			}
-			res = append(res,
-				"--symlink",
-				sysPath,
-				k,
-			)
+			res = append(res, symlinkMount(sysPath, k))
 		}
 		if len(devPath) > 0 {
-			res = append(res,
-				"--dev-bind",
-				devPath,
-				devPath,
-			)
+			res = append(res, bindMount(mountDevBind, devPath, devPath))
 		}
 		if len(sysPath) > 0 {
-			res = append(res,
-				"--dev-bind",
-				sysPath,
-				sysPath,
-			)
+			res = append(res, bindMount(mountDevBind, sysPath, sysPath))
 		}
 	}
 	return res, nil
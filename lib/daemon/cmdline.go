@@ -41,7 +41,6 @@ func resetDocs (config Config) {
 
 func cmdlineDispatcher(cmdChan chan int8, config *Config, exposeChan chan map[string]string) {
 	var skipCount	int
-	var hasExpose	bool
 	var fileFwd	bool
 	var wg		sync.WaitGroup
 	var exposeMap = map[string]string{}
@@ -76,7 +75,6 @@ func cmdlineDispatcher(cmdChan chan int8, config *Config, exposeChan chan map[st
 					pecho("warn", "Rejecting non absolute path")
 					continue
 				}
-				hasExpose = true
 				skipCount += 2
 				exposeMap[cmdlineArray[index + 1]] = cmdlineArray[index + 2]
 			case "--actions" :
@@ -129,12 +127,61 @@ func cmdlineDispatcher(cmdChan chan int8, config *Config, exposeChan chan map[st
 				case "stat", "stats":
 					showStats(*config)
 					abortChan <- true
+				case "schedule":
+					var args []string
+					if len(cmdlineArray) > index + 2 {
+						args = append(args, cmdlineArray[index + 2])
+						skipCount++
+					}
+					if len(args) > 0 && args[0] == "remove" && len(cmdlineArray) > index + 3 {
+						args = append(args, cmdlineArray[index + 3])
+						skipCount++
+					}
+					scheduleAction(*config, args)
+					abortChan <- true
+				case "audit-log":
+					printAuditLog(*config)
+					abortChan <- true
+				case "gc":
+					collectGarbage(*config)
+					abortChan <- true
+				case "list-profiles":
+					err := listProfiles(*config)
+					if err != nil {
+						pecho("warn", "Could not list profiles:", err)
+					}
+					abortChan <- true
+				case "delete-profile":
+					skipCount++
+					if len(cmdlineArray) <= index + 2 {
+						pecho("warn", "--actions delete-profile requires a profile name")
+					} else if err := deleteProfile(*config, cmdlineArray[index + 2]); err != nil {
+						pecho("warn", "Could not delete profile:", err)
+					}
+					abortChan <- true
+				case "network":
+					skipCount++
+					if len(cmdlineArray) <= index + 2 {
+						pecho("warn", "--actions network requires on or off")
+					} else {
+						setNetworkRemote(*config, cmdlineArray[index + 2])
+					}
+					abortChan <- true
 				case "f5aaebc6-0014-4d30-beba-72bce57e0650":
 					pecho("warn", "Portable has removed the ability to start in unsafe mode, please use the legacy version instead")
 					abortChan <- true
 				default:
 					pecho("warn", "Unrecognised action: " + cmdlineArray[index + 1])
 			}
+			case "--headless":
+				runtimeOpt.headless = true
+			case "--profile":
+				// Applied with the configuration, see applyProfile
+				skipCount++
+				pecho("debug", "Using profile " + config.profile)
+			case "--ephemeral", "--ephemeral-snapshot":
+				// Applied with the configuration, see applyEphemeral
+				pecho("debug", "Starting ephemeral instance " + config.Metadata.AppID)
 			case "--dbus-activation":
 				addEnv("_portableBusActivate=1")
 				if ! config.BusActivation.Enable {
@@ -142,6 +189,9 @@ func cmdlineDispatcher(cmdChan chan int8, config *Config, exposeChan chan map[st
 				}
 			case "--":
 				runtimeOpt.argStop = true
+			case "--dry-run":
+				printEnvPolicy(*config)
+				abortChan <- true
 			case "--help":
 				printHelp()
 				pecho("crit", "Aborted start to print help")
@@ -149,16 +199,7 @@ func cmdlineDispatcher(cmdChan chan int8, config *Config, exposeChan chan map[st
 				pecho("warn", "Unrecognised option: " + value)
 		}
 	}
-	wg.Go(func() {
-		if ! hasExpose {
-			return
-		}
-		exposeList := []string{}
-		for key := range exposeMap {
-			exposeList = append(exposeList, key)
-		}
-		exposeChan <- exposeMap
-	})
+	runtimeOpt.exposeMap = exposeMap
 	wg.Go(func() {
 		var mp = make(map[string]string)
 		if ! fileFwd {
@@ -208,6 +249,11 @@ func shareFileViaHelper(config Config, directory bool) error {
 	if call.Err != nil {
 		return call.Err
 	}
+	if directory {
+		audit(config, "share", auditRequested, "", "directory")
+	} else {
+		audit(config, "share", auditRequested, "", "file")
+	}
 	return nil
 }
 
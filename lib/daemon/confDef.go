@@ -9,10 +9,23 @@ type Config struct {
 	Network		NetworkOpts
 	Privacy		PrivacyOpts
 	Advanced	AdvancedOpts
+	Hooks		HookOpts
+	Schedule	ScheduleOpts
+	Environment	EnvOpts
+	Locale		LocaleOpts
+	Theme		ThemeOpts
+	Profiles	ProfileOpts
 	Path		string
 	isModern	bool
 	isDebug		bool
 	isBusActivate	bool
+	// Started with --ephemeral, see ephemeral.go
+	ephemeral	bool
+	ephemeralSnapshot	bool
+	// Named profile selected with --profile, see profile.go
+	profile		string
+	// Application ID before --profile or --ephemeral changed it
+	baseAppID	string
 }
 
 type Metadata struct {
@@ -36,6 +49,70 @@ type BusLaunch struct {
 type ProcMgmt struct {
 	Track		bool
 	Background	bool
+	// Seconds to wait for the application to exit before killing it
+	StopTimeout	int
+	// Restart policy of the main application: no, on-failure or always
+	Restart		string
+	// Seconds to wait before restarting the application
+	RestartDelay	int
+	// Maximum restarts within a minute before giving up
+	RestartBurst	int
+	// Seconds without activity before the sandbox is stopped, 0 disables
+	IdleTimeout	int
+	// Watchdog interval in seconds, 0 disables
+	WatchdogSec	int
+	// Watchdog mode: unit, where the application feeds WATCHDOG=1, or ping, where the daemon pings the helper
+	Watchdog	string
+	// Missed pings before the ping watchdog acts
+	WatchdogMisses	int
+	// Action of the ping watchdog: stop or restart
+	WatchdogAction	string
+}
+
+// Per-application overrides of the host locale and timezone
+type LocaleOpts struct {
+	// Value of LANG, e.g. en_US.UTF-8. Replaces LANG, LANGUAGE and LC_* of the host
+	Language	string
+	// Timezone name like Asia/Shanghai, replaces TZ and /etc/localtime of the host
+	Timezone	string
+}
+
+// Named state profiles, see profile.go
+type ProfileOpts struct {
+	// Directory of profiles relative to XDG_DATA_HOME, defaults to profiles in the state directory
+	Directory	string
+}
+
+// Desktop appearance shared with the sandbox, see theme.go
+type ThemeOpts struct {
+	// Built-in profiles: gtk, qt, cursor, icons, fonts
+	Profiles	[]string
+	// Additional paths bound read-only, relative ones are resolved against the home directory
+	Paths		[]string
+}
+
+// Extends the host environment passthrough policy, see envPolicy.go
+type EnvOpts struct {
+	// Host variables to pass into the sandbox, * matches any characters
+	Allow		[]string
+	// Host variables to block, takes precedence over Allow
+	Deny		[]string
+}
+
+// Timers launching the application, see schedule.go
+type ScheduleOpts struct {
+	// OnCalendar specifications, see systemd.time(7)
+	Calendar	[]string
+	// How scheduled launches start the application: headless or dbus
+	Mode		string
+}
+
+// Host commands run unsandboxed by the daemon, see hooks.go
+type HookOpts struct {
+	PreStart	[]string
+	PostStop	[]string
+	// Seconds each command may run before being killed
+	Timeout		int
 }
 
 type SysMgmt struct {
@@ -44,6 +121,8 @@ type SysMgmt struct {
 
 	// New-style device allow slice, possible values: (dgpu, input, camera, kvm)
 	DeviceAllow	[]string
+	// Supplementary groups of the user listed in the sandbox's /etc/group, e.g. video or render
+	SupplementaryGroups	[]string
 	// Utilisation clamping, see https://docs.kernel.org/scheduler/sched-util-clamp.html
 	Uclamp		string
 
@@ -67,6 +146,17 @@ type PrivacyOpts struct {
 
 	PipeWire		bool
 
+	// Input method to support: auto, none, or one of fcitx, ibus, kime, uim, hime, gcin
+	InputMethod		string
+
+	// Exposure of the host /etc: full or minimal, defaults to minimal with lockdown
+	Etc			string
+	// Additional entries of /etc visible in minimal mode, relative to /etc
+	EtcAllow		[]string
+
+	// Generic host name, per-app machine ID and no real name of the user
+	AnonymizeIdentity	bool
+
 	// Deprecated: do not use
 	Cameras			bool
 	Input			bool
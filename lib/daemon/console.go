@@ -13,10 +13,10 @@ func setRawConsole() {
 		return
 	}
 
-	stopFuncChan <- func() {
+	addStopHook(stageConsole, func() {
 		err := term.Restore(int(os.Stdin.Fd()), oldState)
 		if err != nil {
 			pecho("warn", "Could not restore console state:", err)
 		}
-	}
+	})
 }
\ No newline at end of file
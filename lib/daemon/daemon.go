@@ -45,7 +45,7 @@ func sanityChecks(config Config) {
 	if config.Exec.Overlay {
 		stat, err := os.Stat(filepath.Join(
 			"/usr/lib/portable/info",
-			config.Metadata.AppID,
+			installedAppID(config),
 			"bin",
 		))
 		if err != nil {
@@ -55,6 +55,21 @@ func sanityChecks(config Config) {
 			pecho("crit", "Invalid overlay directory:", "not a directory")
 		}
 	}
+	switch config.Processes.Restart {
+		case "", "no", "on-failure", "always":
+		default:
+			pecho("warn", "Unrecognised restart policy " + config.Processes.Restart + ", not restarting")
+	}
+	switch config.Processes.Watchdog {
+		case "unit", "ping":
+		default:
+			pecho("warn", "Unrecognised watchdog mode " + config.Processes.Watchdog + ", watchdog disabled")
+	}
+	switch config.Processes.WatchdogAction {
+		case "stop", "restart":
+		default:
+			pecho("warn", "Unrecognised watchdog action " + config.Processes.WatchdogAction + ", stopping instead")
+	}
 	if sdutil.IsRunningSystemd() == false {
 		pecho("crit", "Portable requires the systemd service manager")
 	}
@@ -154,30 +169,38 @@ func tryUnquote(input string) (output string) {
 	return
 }
 
-func setFirewall(config Config) {
+// Identifies a sandbox and its rules to netsock
+type netsockSig struct {
+	CgroupNested		string
+	RawDenyList		[]string
+	SandboxEng		string
+	AppID			string
+}
+
+func genNetsockSig(config Config, denyList []string) netsockSig {
+	var sig netsockSig
+	sig.RawDenyList = denyList
+	sig.AppID = config.Metadata.AppID
+	sig.SandboxEng = "top.kimiblock.portable"
+	sig.CgroupNested = "app.slice/app-portable-" + config.Metadata.AppID + "-" + runtimeInfo.instanceID + ".service/portable-cgroup"
+	return sig
+}
+
+func setFirewall(config Config) error {
 	var wg sync.WaitGroup
+	var err error
 	denyList := config.Network.FilterDest
 	if ! config.Network.Filter {
 		pecho("debug", "Network filtering disabled")
-		return
+		return nil
 	}
 
 	pecho("debug", "Decoded raw deny list: " + strings.Join(denyList, ", "))
-	type IncomingSig struct {
-		CgroupNested		string
-		RawDenyList		[]string
-		SandboxEng		string
-		AppID			string
-	}
-	var sig IncomingSig
-	sig.RawDenyList = denyList
-	sig.AppID = config.Metadata.AppID
-	sig.SandboxEng = "top.kimiblock.portable"
-	sig.CgroupNested = "app.slice/app-portable-" + config.Metadata.AppID + "-" + runtimeInfo.instanceID + ".service/portable-cgroup"
+	sig := genNetsockSig(config, denyList)
 
 	rules := make(chan string, 1)
 	wg.Go(func() {
-		go dialNetsock(rules)
+		err = dialNetsock("/add", rules)
 	})
 
 
@@ -185,17 +208,17 @@ func setFirewall(config Config) {
 	jsonObj, encodeErr := json.Marshal(sig)
 	if encodeErr != nil {
 		pecho("crit", "Could not decode network restriction list: " + encodeErr.Error())
-		return
+		return encodeErr
 	}
 
 	rules <- string(jsonObj)
 	close(rules)
 	wg.Wait()
-
-
+	return err
 }
 
-func dialNetsock(rules chan string) {
+// Posts rules to an endpoint of netsock, e.g. /add or /remove
+func dialNetsock(endpoint string, rules chan string) error {
 	// Copied from netsock
 	type ResponseSignal struct {
 		Success			bool
@@ -203,7 +226,7 @@ func dialNetsock(rules chan string) {
 	}
 	transport := http.Transport {
 		Proxy:		nil,
-		Dial:		func(network, addr string) (net.Conn, error) {return net.Dial("unix", "/run/netsock/control.sock")},
+		Dial:		func(network, addr string) (net.Conn, error) {return net.Dial("unix", netsockSocket)},
 	}
 
 	client := http.Client{
@@ -214,10 +237,10 @@ func dialNetsock(rules chan string) {
 	buf := strings.NewReader(<-rules)
 	var resp ResponseSignal
 
-	respPtr, postErr := client.Post("http://127.0.0.114/add", "application/json", buf)
+	respPtr, postErr := client.Post("http://127.0.0.114" + endpoint, "application/json", buf)
 	if postErr != nil {
 		pecho("warn", "Could not post data to netsock: " + postErr.Error())
-		return
+		return postErr
 	}
 	defer respPtr.Body.Close()
 
@@ -226,18 +249,24 @@ func dialNetsock(rules chan string) {
 	err := decoder.Decode(&resp)
 	if err != nil {
 		pecho("warn", "Could not decode response from netsock: " + err.Error())
-		return
+		return err
 	}
 	if resp.Success == true {
 		pecho("debug", "Firewall active")
 	} else {
 		pecho("warn", "netsock respond with: " + resp.Log)
+		return errors.New("netsock respond with: " + resp.Log)
 	}
+	return nil
 }
 
-func genInstanceID(genInfo chan int8, proceed chan int8, config Config) {
+func genInstanceID(conn *dbus.Conn, genInfo chan int8, proceed chan int8, config Config) {
 	var wg sync.WaitGroup
 	pecho("debug", "Generating instance ID")
+	busConn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("warn", "Could not connect to session bus:", err)
+	}
 	for {
 		idCandidate := rand.Intn(2147483647)
 		pecho("debug", "Trying instance ID: " + strconv.Itoa(idCandidate))
@@ -248,14 +277,31 @@ func genInstanceID(genInfo chan int8, proceed chan int8, config Config) {
 			break
 		} else if err != nil {
 			pecho("crit", "Could not stat instance path:", err)
+		} else if reclaimInstance(conn, busConn, strconv.Itoa(idCandidate)) {
+			runtimeInfo.instanceID = strconv.Itoa(idCandidate)
+			genInfo <- 1
+			break
 		} else {
 			pecho("warn", "Unable to use instance ID " + strconv.Itoa(idCandidate))
 		}
 	}
+	setLogField("INSTANCE_ID", runtimeInfo.instanceID)
+	go func () {
+		removed := gcInstances(conn, busConn, runtimeInfo.instanceID)
+		if removed > 0 {
+			pecho("info", "Removed " + strconv.Itoa(removed) + " stale instance(s)")
+		}
+	} ()
 	<- proceed
 	wg.Go(func() {
 		generatePasswdFile(config)
 	})
+	wg.Go(func() {
+		generateGroupFile(config)
+	})
+	wg.Go(func() {
+		generateIdentityFiles(config)
+	})
 	wg.Go(func() {
 		generateNsswitch(config)
 	})
@@ -393,9 +439,15 @@ func lookUpXDG() {
 		xdgDir.dataDir = xdgDir.home + "/.local/share"
 		pecho("debug", "Using default data home: " + xdgDir.dataDir)
 	}
+
+	if len(os.Getenv("XDG_STATE_HOME")) > 0 {
+		xdgDir.stateDir = os.Getenv("XDG_STATE_HOME")
+	} else {
+		xdgDir.stateDir = xdgDir.home + "/.local/state"
+	}
 }
 
-func pwSecContext(pwChan chan []string, config Config) {
+func pwSecContext(pwChan chan []Mount, config Config) {
 	var pwProxySocket string
 	if config.Privacy.PipeWire == false {
 		close(pwChan)
@@ -432,10 +484,8 @@ func pwSecContext(pwChan chan []string, config Config) {
 			break
 		}
 	}
-	pwChan <- []string{
-		"--bind",
-		pwProxySocket,
-		filepath.Join(xdgDir.runtimeDir, "pipewire-0"),
+	pwChan <- []Mount{
+		bindMount(mountBind, pwProxySocket, filepath.Join(xdgDir.runtimeDir, "pipewire-0")),
 	}
 	close(pwChan)
 	pecho("debug", "pw-container available at " + pwProxySocket)
@@ -445,6 +495,46 @@ func pwSecContext(pwChan chan []string, config Config) {
 	}
 }
 
+// Names the application may own through the D-Bus proxy. Ephemeral instances and profiles
+// only get names carrying their suffix, so they can not claim those of the default instance
+func proxyOwnArgs(config Config) []string {
+	appID := config.Metadata.AppID
+	suffix := strings.TrimPrefix(appID, installedAppID(config))
+	ownList := []string{
+		"--own=" + appID,
+		"--own=" + appID + ".*",
+	}
+	// Shitty MPRIS calc code
+	/* Take an app ID top.kimiblock.test for example
+		appIDSplit would have 3 substrings
+		appIDSepNum would be 3
+		so appIDSplit[3 - 1] should be the last part
+	*/
+	appIDSplit := strings.Split(installedAppID(config), ".")
+	appIDSegNum := len(appIDSplit)
+	var appIDLastSeg string = appIDSplit[appIDSegNum - 1] + suffix
+	ownList = append(
+		ownList,
+		"--own=org.mpris.MediaPlayer2." + appID,
+		"--own=org.mpris.MediaPlayer2." + appID + ".*",
+		"--own=org.mpris.MediaPlayer2." + appIDLastSeg,
+		"--own=org.mpris.MediaPlayer2." + appIDLastSeg + ".*",
+	)
+	if len(config.Advanced.MprisName) == 0 {
+		pecho("debug", "Using default MPRIS own name")
+	} else {
+		for _, name := range config.Advanced.MprisName {
+			ownList = append(
+				ownList,
+				"--own=org.mpris.MediaPlayer2." + name + suffix,
+				"--own=org.mpris.MediaPlayer2." + name + suffix + ".*",
+			)
+		}
+
+	}
+	return ownList
+}
+
 func calcDbusArg(argChan chan []string, docMnt string, config Config) {
 	argList := []string{
 		"bwrap",
@@ -469,8 +559,6 @@ func calcDbusArg(argChan chan []string, docMnt string, config Config) {
 		"--filter",
 		"--own=com.belmoussaoui.ashpd.demo",
 		"--talk=org.unifiedpush.Distributor.*",
-		"--own=" + config.Metadata.AppID,
-		"--own=" + config.Metadata.AppID + ".*",
 		"--talk=com.canonical.AppMenu.Registrar",
 		"--see=org.a11y.Bus",
 		"--call=org.a11y.Bus=org.a11y.Bus.GetAddress@/org/a11y/bus",
@@ -528,19 +616,12 @@ func calcDbusArg(argChan chan []string, docMnt string, config Config) {
 
 	pecho("debug", "Expanding built-in rules")
 
-	allowedTalks := []string{
-		"org.freedesktop.portal.Documents",
-		"org.freedesktop.portal.Fcitx",
-		"org.freedesktop.portal.IBus",
-	}
-
-	for _, talkDest := range allowedTalks {
-		argList = append(
-			argList,
-			"--talk=" + talkDest,
-			"--call=" + talkDest + "=*",
-		)
-	}
+	argList = append(
+		argList,
+		"--talk=org.freedesktop.portal.Documents",
+		"--call=org.freedesktop.portal.Documents=*",
+	)
+	argList = append(argList, imTalkArgs(config)...)
 
 	if internalLoggingLevel <= 1 {
 		argList = append(argList, "--log")
@@ -550,35 +631,7 @@ func calcDbusArg(argChan chan []string, docMnt string, config Config) {
 		pecho("crit", "Could not create documents path: " + err.Error())
 	}
 
-	// Shitty MPRIS calc code
-	mprisOwnList := []string{}
-	/* Take an app ID top.kimiblock.test for example
-		appIDSplit would have 3 substrings
-		appIDSepNum would be 3
-		so appIDSplit[3 - 1] should be the last part
-	*/
-	appIDSplit := strings.Split(config.Metadata.AppID, ".")
-	appIDSegNum := len(appIDSplit)
-	var appIDLastSeg string = appIDSplit[appIDSegNum - 1]
-	mprisOwnList = append(
-		mprisOwnList,
-		"--own=org.mpris.MediaPlayer2." + config.Metadata.AppID,
-		"--own=org.mpris.MediaPlayer2." + config.Metadata.AppID + ".*",
-		"--own=org.mpris.MediaPlayer2." + appIDLastSeg,
-		"--own=org.mpris.MediaPlayer2." + appIDLastSeg + ".*",
-	)
-	if len(config.Advanced.MprisName) == 0 {
-		pecho("debug", "Using default MPRIS own name")
-	} else {
-		for _, name := range config.Advanced.MprisName {
-			mprisOwnList = append(
-				mprisOwnList,
-				"--own=org.mpris.MediaPlayer2." + name,
-				"--own=org.mpris.MediaPlayer2." + name + ".*",
-			)
-		}
-
-	}
+	ownList := proxyOwnArgs(config)
 
 	if config.Privacy.ClassicNotifications {
 		argList = append(
@@ -597,7 +650,7 @@ func calcDbusArg(argChan chan []string, docMnt string, config Config) {
 
 	argList = append(
 		argList,
-		mprisOwnList...
+		ownList...
 	)
 
 	var numCPUs = runtime.NumCPU()
@@ -613,45 +666,6 @@ func calcDbusArg(argChan chan []string, docMnt string, config Config) {
 	argChan <- argList
 }
 
-func doCleanUnit(conn *dbus.Conn, sdCancelFunc func(), sdContext context.Context, config Config) {
-	defer sdCancelFunc()
-	var wg sync.WaitGroup
-	var units = []string{
-		config.Metadata.FriendlyName + "-" + runtimeInfo.instanceID + "-dbus.service",
-	}
-	for _, unit := range units {
-		wg.Add(1)
-		go func (u string) {
-			defer wg.Done()
-			errBus := conn.KillUnitWithTarget(
-				sdContext,
-				u,
-				dbus.All,
-				15,
-			)
-			if errBus != nil {
-				if strings.Contains(u, "-dbus") {
-					pecho(
-						"warn",
-						"User manager returned error: " + errBus.Error(),
-					)
-				} else {
-					pecho(
-						"debug",
-						"User manager returned error: " + errBus.Error(),
-					)
-				}
-			} else {
-
-			}
-		} (unit)
-	}
-	wg.Wait()
-	conn.Close()
-
-	pecho("debug", "Cleaning ready")
-}
-
 func startProxy(conn *dbus.Conn, ctx context.Context, config Config) {
 	dbusProps := []dbus.Property{}
 	var wg sync.WaitGroup
@@ -722,6 +736,7 @@ func startProxy(conn *dbus.Conn, ctx context.Context, config Config) {
 type DBusPingRequest struct {}
 type DBusControlRequest struct {
 	Conn		*godbus.Conn
+	Config		Config
 	stopSig		chan int
 }
 
@@ -755,6 +770,8 @@ func (m *DBusInfoRequest) GetInfo() ([]string, *godbus.Error) {
 		"Instance ID: " + runtimeInfo.instanceID,
 		"Unit name: " + "app-portable-" + m.Config.Metadata.AppID + "-" + runtimeInfo.instanceID,
 		"Started since: " + m.TimeStart.String(),
+		"Network: " + networkState(m.Config),
+		"Layout hash: " + runtimeInfo.layoutHash,
 	}
 	if runtimeInfo.instanceID == "" {
 		return []string{}, godbus.MakeFailedError(errors.New("Instance ID unknown"))
@@ -852,6 +869,7 @@ func busListener(conn *godbus.Conn, ready chan int8, sdConn *dbus.Conn, stopSig
 	info.Conn = conn
 	info.TimeStart = time.Now()
 	controller.Conn = conn
+	controller.Config = config
 	controller.stopSig = stopSig
 	objPath := godbus.ObjectPath("/top/kimiblock/portable/daemon")
 	node := &introspect.Node{
@@ -889,10 +907,60 @@ func busListener(conn *godbus.Conn, ready chan int8, sdConn *dbus.Conn, stopSig
 			},
 			{
 				Name:		"top.kimiblock.Portable.Controller",
+				Signals:	[]introspect.Signal{
+					{
+						Name:	"Exited",
+						Args:	[]introspect.Arg{
+							{
+								Name:	"Code",
+								Type:	"i",
+							},
+							{
+								Name:	"Result",
+								Type:	"s",
+							},
+						},
+					},
+				},
 				Methods:	[]introspect.Method{
 					{
 						Name:	"Stop",
 					},
+					{
+						Name:	"SetNetwork",
+						Args:	[]introspect.Arg{
+							{
+								Name:		"Enabled",
+								Type:		"b",
+								Direction:	"in",
+							},
+						},
+					},
+					{
+						Name:	"Expose",
+						Args:	[]introspect.Arg{
+							{
+								Name:		"Host",
+								Type:		"s",
+								Direction:	"in",
+							},
+							{
+								Name:		"Destination",
+								Type:		"s",
+								Direction:	"in",
+							},
+							{
+								Name:		"Flags",
+								Type:		"u",
+								Direction:	"in",
+							},
+							{
+								Name:		"SandboxPath",
+								Type:		"s",
+								Direction:	"out",
+							},
+						},
+					},
 				},
 			},
 		},
@@ -917,12 +985,13 @@ func busListener(conn *godbus.Conn, ready chan int8, sdConn *dbus.Conn, stopSig
 		pecho("crit", "Could not export bus method: " + err.Error())
 		return
 	}
+	go listenVarlink(controller, info, config)
 
 	ready <- 1
 	select {}
 }
 
-func startApp(config Config, argChan chan bwArgs, stopSig chan int) {
+func startApp(conn *dbus.Conn, config Config, argChan chan bwArgs, stopSig chan int) {
 	go forceBackgroundPerm(config)
 
 	var sdArgs []string
@@ -945,19 +1014,46 @@ func startApp(config Config, argChan chan bwArgs, stopSig chan int) {
 		)
 	}
 
-	pecho("debug", "Calculated arguments for systemd-run:", sdArgs)
-	sdExec := exec.Command("systemd-run", sdArgs...)
-	sdExec.Stderr = os.Stderr
-	sdExec.Stdout = os.Stdout
-	sdExec.Stdin = os.Stdin
 	<- envsFlushReady
-	// Profiler
-	//pprof.Lookup("block").WriteTo(os.Stdout, 1)
-	sdExecErr := sdExec.Run()
-	if sdExecErr != nil {
-		pecho("warn", "systemd-run exited with non-zero code:", sdExecErr)
+	sdArgs = slices.Insert(sdArgs, slices.Index(sdArgs, "--"), envUnsetArgs(config)...)
+	pecho("debug", "Calculated arguments for systemd-run:", sdArgs)
+	var tracker restartTracker
+	var status appExit
+	setLogField("PHASE", "running")
+	for {
+		sdExec := exec.Command("systemd-run", sdArgs...)
+		sdExec.Stderr = os.Stderr
+		sdExec.Stdout = os.Stdout
+		sdExec.Stdin = os.Stdin
+		// Profiler
+		//pprof.Lookup("block").WriteTo(os.Stdout, 1)
+		sdExecErr := sdExec.Run()
+		status = appExitStatus(conn, config, sdExecErr)
+		trip := watchdogTrip.Swap(watchdogNone)
+		if trip != watchdogNone {
+			status = appExit{Code: watchdogExitCode, Result: "watchdog"}
+		}
+		if status.Code != 0 {
+			pecho("warn", "Application exited with code", status.Code, "(" + status.Result + ")")
+		}
+		emitExited(config, status)
+		var restart bool
+		switch trip {
+			case watchdogRestart:
+				restart = tracker.allowRestart(config)
+			case watchdogStop:
+				restart = false
+			default:
+				restart = tracker.shouldRestart(config, status.Code != 0)
+		}
+		if ! restart {
+			break
+		}
+		if ! prepareRestart(conn, config) {
+			break
+		}
 	}
-	stopSig <- 0
+	stopSig <- status.Code
 }
 
 func forceBackgroundPerm(config Config) {
@@ -994,6 +1090,35 @@ func forceBackgroundPerm(config Config) {
 	}
 }
 
+// Content of the stub .desktop file installed when the application ships none
+func stubDesktopEntry(config Config) string {
+	const templateDesktopFile string = "[Desktop Entry]\nName=placeholderName\nExec=env placeholderVar=placeholderConfig portable\nTerminal=false\nType=Application\nIcon=image-missing\nComment=Application info missing\n"
+
+	var placeholderVar string
+	if config.isModern {
+		placeholderVar = "PORTABLE_CONF"
+	} else {
+		placeholderVar = "_portableConfig"
+	}
+	replacer := strings.NewReplacer(
+		"placeholderName",		"Portable app: " + config.Metadata.AppID,
+		"placeholderConfig",		config.Path,
+		"placeholderVar",		placeholderVar,
+	)
+	entry := replacer.Replace(templateDesktopFile)
+	if installedAppID(config) == config.Metadata.AppID {
+		return entry
+	}
+	// Profiles and ephemeral instances only need the entry for portals to resolve their
+	// ID, so it stays out of menus and launches the same variant
+	var launchArgs string
+	for _, arg := range instanceArgs(config) {
+		launchArgs = launchArgs + " " + arg
+	}
+	entry = strings.Replace(entry, " portable\n", " portable" + launchArgs + "\n", 1)
+	return entry + "NoDisplay=true\n"
+}
+
 func instDesktopFile(config Config) {
 	var wg sync.WaitGroup
 	wg.Go(func() {
@@ -1038,19 +1163,6 @@ func instDesktopFile(config Config) {
 		}
 	}
 
-	const templateDesktopFile string = "[Desktop Entry]\nName=placeholderName\nExec=env placeholderVar=placeholderConfig portable\nTerminal=false\nType=Application\nIcon=image-missing\nComment=Application info missing\n"
-
-	var placeholderVar string
-	if config.isModern {
-		placeholderVar = "PORTABLE_CONF"
-	} else {
-		placeholderVar = "_portableConfig"
-	}
-	replacer := strings.NewReplacer(
-		"placeholderName",		"Portable app: " + config.Metadata.AppID,
-		"placeholderConfig",		config.Path,
-		"placeholderVar",		placeholderVar,
-	)
 	wg.Wait()
 
 	filePath := filepath.Join(
@@ -1070,18 +1182,18 @@ func instDesktopFile(config Config) {
 		return
 	}
 	defer file.Close()
-	_, err = replacer.WriteString(file, templateDesktopFile)
+	_, err = file.WriteString(stubDesktopEntry(config))
 	if err != nil {
 		pecho("warn", "Could not write .desktop file: " + err.Error())
 		pecho("warn", "Non-existent .desktop file may result in Portals crashing")
 		return
 	}
-	stopFuncChan <- func() {
+	addStopHook(stageRuntimeDir, func() {
 		err := os.Remove(filePath)
 		if err != nil {
 			pecho("debug", "Could not remove .desktop file:", err)
 		}
-	}
+	})
 	pecho("debug", "Done installing stub file")
 	pecho("warn", "You should supply your own .desktop file")
 }
@@ -1102,91 +1214,9 @@ func setXDGEnvs(config Config) {
 	addEnv("XDG_RUNTIME_DIR=" + xdgDir.runtimeDir)
 }
 
-func imEnvs (config Config) {
-	addEnv("IBUS_USE_PORTAL=1")
-	var imKind string
-	if ! config.Privacy.X11 {
-		addEnv("QT_IM_MODULE=wayland")
-		addEnv("GTK_IM_MODULE=wayland")
-	} else {
-		var envToCheck = []string{
-			"XMODIFIERS",
-			"QT_IM_MODULE",
-			"GTK_IM_MODULE",
-		}
-		for _, env := range envToCheck {
-			if strings.Contains(os.Getenv(env), "fcitx") == true {
-				imKind = "Fcitx 5"
-				break
-			} else if strings.Contains(os.Getenv(env), "ibus") == true {
-				imKind = "iBus"
-				break
-			} else if strings.Contains(os.Getenv(env), "gcin") == true {
-				imKind = "gcin"
-				break
-			}
-		}
-		pecho("debug", "Determined input method type: " + imKind)
-		switch imKind {
-			case "Fcitx 5":
-				//addEnv("GTK_IM_MODULE=fcitx")
-				addEnv("QT_IM_MODULE=fcitx")
-				addEnv("QT_IM_MODULES=wayland;fcitx")
-			case "iBus":
-				addEnv("QT_IM_MODULES=wayland;ibus")
-				addEnv("QT_IM_MODULE=ibus")
-				//addEnv("GTK_IM_MODULE=ibus")
-			case "gcin":
-				addEnv("QT_IM_MODULES=wayland;ibus")
-				addEnv("QT_IM_MODULE=ibus")
-				addEnv("GTK_IM_MODULE=gcin")
-			default:
-				pecho("warn", "Could not determine IM via environment variables")
-				procEntries, err := os.ReadDir("/proc")
-				if err != nil {
-					pecho(
-					"warn",
-					"Could not determine input method via process lookup: " + err.Error(),
-					)
-				} else {
-					for _, pid := range procEntries {
-						if _, err := strconv.Atoi(pid.Name()); err != nil {
-							continue
-						}
-						commFd, fdErr := os.OpenFile(
-							"/proc/" + pid.Name() + "/comm",
-							os.O_RDONLY,
-							0644,
-						)
-						if fdErr == nil {
-							scanner := bufio.NewScanner(commFd)
-							for scanner.Scan() {
-								line := scanner.Text()
-								if strings.Contains(line, "fcitx") {
-									pecho("debug", "Guessing IM: Fcitx")
-									//addEnv("GTK_IM_MODULE=fcitx")
-									addEnv("QT_IM_MODULES=wayland;fcitx")
-									addEnv("QT_IM_MODULE=fcitx")
-									break
-								} else if strings.Contains(line, "ibus") {
-									pecho("debug", "Guessing IM: iBus")
-									addEnv("QT_IM_MODULE=ibus")
-									addEnv("QT_IM_MODULES=wayland;ibus")
-									//addEnv("GTK_IM_MODULE=ibus")
-									break
-								}
-							}
-							commFd.Close()
-						}
-					}
-				}
-		}
-	}
-}
-
 func setupSharedDir (config Config) {
 	err := os.MkdirAll(
-		filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory, "Shared"),
+		filepath.Join(stateSource(config), "Shared"),
 		0700,
 	)
 	if err != nil {
@@ -1194,7 +1224,7 @@ func setupSharedDir (config Config) {
 	}
 	err = os.Symlink(
 		filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory, "Shared"),
-		filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory, "共享文件"),
+		filepath.Join(stateSource(config), "共享文件"),
 	)
 	if err != nil {
 		if os.IsExist(err) {} else {
@@ -1206,10 +1236,6 @@ func setupSharedDir (config Config) {
 func miscEnvs (config Config) {
 	addEnv("_portableUclampMax=" + config.System.Uclamp)
 
-	if val := os.Getenv("XDG_ACTIVATION_TOKEN"); len(val) > 0 {
-		addEnv("XDG_ACTIVATION_TOKEN=" + val)
-	}
-
 	if config.isModern {
 		addEnv("_portableConfigType=modern")
 	} else {
@@ -1253,7 +1279,6 @@ func miscEnvs (config Config) {
 	addEnv("QT_AUTO_SCREEN_SCALE_FACTOR=1")
 	addEnv("QT_ENABLE_HIGHDPI_SCALING=1")
 	addEnv("PS1=" + strconv.Quote("╰─>Portable·" + config.Metadata.AppID + "·🤓 ⤔ "))
-	addEnv("QT_SCALE_FACTOR=" + os.Getenv("QT_SCALE_FACTOR"))
 	addEnv("HOME=" + filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory))
 	addEnv("XDG_SESSION_TYPE=" + os.Getenv("XDG_SESSION_TYPE"))
 	addEnv("WAYLAND_DISPLAY=" + filepath.Join(xdgDir.runtimeDir, "wayland-0"))
@@ -1286,6 +1311,15 @@ func prepareEnvs(config Config) {
 	wg.Go(func() {
 		miscEnvs(config)
 	})
+	wg.Go(func() {
+		passthroughEnvs(config)
+	})
+	wg.Go(func() {
+		localeEnvs(config)
+	})
+	wg.Go(func() {
+		themeEnvs(config)
+	})
 	wg.Go(func() {
 		statePath := filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory, "portable.env")
 		userEnvs, err := os.OpenFile(
@@ -1328,11 +1362,11 @@ func prepareEnvs(config Config) {
 }
 
 func genBwArg(
-	xChan chan []string,
-	camChan chan []string,
-	inputChan chan []string,
-	wayDisplayChan chan []string,
-	miscChan	chan []string,
+	xChan chan []Mount,
+	camChan chan []Mount,
+	inputChan chan []Mount,
+	wayDisplayChan chan []Mount,
+	miscChan	chan []Mount,
 	docMnt		string,
 	config Config,
 	) (bwArgs) {
@@ -1346,6 +1380,14 @@ func genBwArg(
 			arg = append(arg, sig...)
 		}
 	})
+	var mounts mountBuilder
+	var mountChan = make(chan []Mount, 10)
+	var mountWg sync.WaitGroup
+	mountWg.Go(func() {
+		for mnt := range mountChan {
+			mounts.add(mnt...)
+		}
+	})
 
 	if internalLoggingLevel > 1 {
 		argChan <- []string{"--quiet"}
@@ -1356,7 +1398,6 @@ func genBwArg(
 		"--user",
 		"--service-type=notify",
 		"--wait",
-		"--pty",
 		"--unit=" + "app-portable-" + config.Metadata.AppID + "-" + runtimeInfo.instanceID,
 		"--slice=app.slice",
 		"-p", "Delegate=yes",
@@ -1366,7 +1407,6 @@ func genBwArg(
 		"-p", "After=pipewire.service pipewire-pulse.service xdg-desktop-portal.service",
 		"-p", "Documentation=https://github.com/Kraftland/portable",
 		"-p", "ExitType=cgroup",
-		"-p", "SuccessExitStatus=SIGKILL",
 		"-p", "NotifyAccess=all",
 		"-p", "TimeoutStartSec=infinity",
 		"-p", "SecureBits=noroot-locked",
@@ -1401,18 +1441,6 @@ func genBwArg(
 		"EnvironmentFile=" + filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "generated.env"),
 		"-p", "Environment=instanceId=" + runtimeInfo.instanceID,
 		"-p", "Environment=busDir=" + filepath.Join(xdgDir.runtimeDir, "app", config.Metadata.AppID),
-		"-p", "UnsetEnvironment=GNOME_SETUP_DISPLAY",
-		"-p", "UnsetEnvironment=PIPEWIRE_REMOTE",
-		"-p", "UnsetEnvironment=PAM_KWALLET5_LOGIN",
-		"-p", "UnsetEnvironment=GTK2_RC_FILES",
-		"-p", "UnsetEnvironment=ICEAUTHORITY",
-		"-p", "UnsetEnvironment=MANAGERPID",
-		"-p", "UnsetEnvironment=INVOCATION_ID",
-		"-p", "UnsetEnvironment=MANAGERPIDFDID",
-		"-p", "UnsetEnvironment=SSH_AUTH_SOCK",
-		"-p", "UnsetEnvironment=VK_LOADER_DRIVERS_DISABLE",
-		"-p", "UnsetEnvironment=MAIL",
-		"-p", "UnsetEnvironment=SYSTEMD_EXEC_PID",
 		"-p", "WorkingDirectory=" + filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory),
 		//"-p", "EnvironmentFile=" + xdgDir.runtimeDir + "/portable/" + confOpts.appID + "/portable-generated-new.env",
 		"-p", "SystemCallFilter=~@clock",
@@ -1441,6 +1469,13 @@ func genBwArg(
 			}
 		}
 	})
+	wg.Go(func() {
+		if runtimeOpt.headless {
+			pecho("debug", "Starting without a terminal")
+			return
+		}
+		argChan <- []string{"--pty"}
+	})
 	wg.Go(func() {
 		if ! config.Network.Enable {
 			pecho("info", "Network Access disabled")
@@ -1453,9 +1488,8 @@ func genBwArg(
 
 	wg.Wait()
 
-	argChan <- []string{
-		"--",
-		"bwrap",
+	// Passed to bwrap together with the mounts via --args, see writeBwrapArgs
+	bwrapOpts := []string{
 		// Unshares
 		"--new-session",
 		"--unshare-cgroup-try",
@@ -1463,140 +1497,157 @@ func genBwArg(
 		"--unshare-uts",
 		"--unshare-pid",
 		"--unshare-user",
+	}
+	bwrapOpts = append(bwrapOpts, identityOpts(config)...)
 
-		"--perms",		"0755",
-		"--dir",		"/host",
-		"--ro-bind-try",	"/opt", "/opt",
-		"--ro-bind",		"/usr", "/usr",
-		"--symlink",		"/usr/lib", "/lib",
-		"--symlink",		"/usr/lib", "/lib64",
-		"--symlink",		"/usr/bin", "/bin",
-		"--symlink",		"/usr/bin", "/sbin",
+	mountChan <- []Mount{
+		{Kind: mountDir, Dest: "/host", Perms: "0755"},
+		tryMount(mountRoBind, "/opt", "/opt"),
+		bindMount(mountRoBind, "/usr", "/usr"),
+		symlinkMount("/usr/lib", "/lib"),
+		symlinkMount("/usr/lib", "/lib64"),
+		symlinkMount("/usr/bin", "/bin"),
+		symlinkMount("/usr/bin", "/sbin"),
 		// Tmp binds
-		"--tmpfs",		"/tmp",
+		tmpfsMount("/tmp"),
 
 		// Dev binds
-		"--dev",		"/dev",
-		"--tmpfs",		"/dev/shm",
-		"--dev-bind-try",	"/dev/mali", "/dev/mali",
-		"--dev-bind-try",	"/dev/mali0", "/dev/mali0",
-		"--dev-bind-try",	"/dev/umplock", "/dev/umplock",
-		"--mqueue",		"/dev/mqueue",
-		"--dev-bind-try",	"/dev/udmabuf", "/dev/udmabuf",
-		"--dev-bind-try",	"/dev/ntsync", "/dev/ntsync",
-		"--dir",		"/top.kimiblock.portable",
+		{Kind: mountDev, Dest: "/dev"},
+		tmpfsMount("/dev/shm"),
+		tryMount(mountDevBind, "/dev/mali", "/dev/mali"),
+		tryMount(mountDevBind, "/dev/mali0", "/dev/mali0"),
+		tryMount(mountDevBind, "/dev/umplock", "/dev/umplock"),
+		{Kind: mountMqueue, Dest: "/dev/mqueue"},
+		tryMount(mountDevBind, "/dev/udmabuf", "/dev/udmabuf"),
+		tryMount(mountDevBind, "/dev/ntsync", "/dev/ntsync"),
+		{Kind: mountDir, Dest: "/top.kimiblock.portable"},
 
 		// Sysfs entries
-		"--tmpfs",		"/sys",
-		"--ro-bind-try",	"/sys/module", "/sys/module",
-		"--ro-bind-try",	"/sys/dev/char", "/sys/dev/char",
-		"--tmpfs",		"/sys/devices",
-		"--tmpfs",		"/sys/block",
-		"--tmpfs",		"/sys/bus",
-		"--bind-try",		"/sys/fs/cgroup", "/sys/fs/cgroup",
-		"--bind-try",		"/sys/devices/system", "/sys/devices/system",
-		"--ro-bind",		"/sys/kernel", "/sys/kernel",
-		"--ro-bind",		"/sys/devices/virtual", "/sys/devices/virtual",
+		tmpfsMount("/sys"),
+		tryMount(mountRoBind, "/sys/module", "/sys/module"),
+		tryMount(mountRoBind, "/sys/dev/char", "/sys/dev/char"),
+		tmpfsMount("/sys/devices"),
+		tmpfsMount("/sys/block"),
+		tmpfsMount("/sys/bus"),
+		tryMount(mountBind, "/sys/fs/cgroup", "/sys/fs/cgroup"),
+		tryMount(mountBind, "/sys/devices/system", "/sys/devices/system"),
+		bindMount(mountRoBind, "/sys/kernel", "/sys/kernel"),
+		bindMount(mountRoBind, "/sys/devices/virtual", "/sys/devices/virtual"),
 
 		// Proc binds
-		"--proc",		"/proc",
-		"--dev-bind-try",	"/dev/null", "/dev/null",
-		"--ro-bind-try",	"/dev/null", "/proc/uptime",
-		"--ro-bind-try",	"/dev/null", "/proc/modules",
-		"--ro-bind-try",	"/dev/null", "/proc/cmdline",
-		"--ro-bind-try",	"/dev/null", "/proc/diskstats",
-		"--ro-bind-try",	"/dev/null", "/proc/devices",
-		"--ro-bind-try",	"/dev/null", "/proc/config.gz",
-		"--ro-bind-try",	"/dev/null", "/proc/loadavg",
+		{Kind: mountProc, Dest: "/proc"},
+		tryMount(mountDevBind, "/dev/null", "/dev/null"),
 
 		// FHS dir
-		"--perms",		"0000",
-		"--tmpfs",		"/boot",
-		"--perms",		"0000",
-		"--tmpfs",		"/srv",
-		"--perms",		"0000",
-		"--tmpfs",		"/root",
-		"--perms",		"0000",
-		"--tmpfs",		"/media",
-		"--perms",		"0000",
-		"--tmpfs",		"/mnt",
-		"--tmpfs",		"/home",
-		"--tmpfs",		"/var",
-		"--symlink",		"/run", "/var/run",
-		"--symlink",		"/run/lock", "/var/lock",
-		"--tmpfs",		"/var/empty",
-		"--tmpfs",		"/var/lib",
-		"--tmpfs",		"/var/log",
-		"--tmpfs",		"/var/opt",
-		"--tmpfs",		"/var/spool",
-		"--tmpfs",		"/var/tmp",
-
-		"--ro-bind-try",	"/var/cache/fontconfig", "/var/cache/fontconfig",
+		{Kind: mountTmpfs, Dest: "/boot", Perms: "0000"},
+		{Kind: mountTmpfs, Dest: "/srv", Perms: "0000"},
+		{Kind: mountTmpfs, Dest: "/root", Perms: "0000"},
+		{Kind: mountTmpfs, Dest: "/media", Perms: "0000"},
+		{Kind: mountTmpfs, Dest: "/mnt", Perms: "0000"},
+		tmpfsMount("/home"),
+		tmpfsMount("/var"),
+		symlinkMount("/run", "/var/run"),
+		symlinkMount("/run/lock", "/var/lock"),
+		tmpfsMount("/var/empty"),
+		tmpfsMount("/var/lib"),
+		tmpfsMount("/var/log"),
+		tmpfsMount("/var/opt"),
+		tmpfsMount("/var/spool"),
+		tmpfsMount("/var/tmp"),
+
+		tryMount(mountRoBind, "/var/cache/fontconfig", "/var/cache/fontconfig"),
 
 		// Run binds
-		"--bind",
+		bindMount(
+			mountBind,
 			filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID),
 			"/run",
-		"--bind",
+		),
+		bindMount(
+			mountBind,
 			filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID),
 			filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID),
+		),
 
-		"--ro-bind",
+		bindMount(
+			mountRoBind,
 			filepath.Join(xdgDir.runtimeDir, "app", config.Metadata.AppID, "bus"),
 			"/run/sessionBus",
-		"--ro-bind-try",
+		),
+		tryMount(
+			mountRoBind,
 			filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "a11y"),
 			filepath.Join(xdgDir.runtimeDir, "at-spi"),
-		"--dir",		"/run/host",
-		"--bind",
+		),
+		{Kind: mountDir, Dest: "/run/host"},
+		bindMount(
+			mountBind,
 			filepath.Join(docMnt, "by-app", config.Metadata.AppID),
 			filepath.Join(docMnt),
-		"--ro-bind-try",
+		),
+		tryMount(
+			mountRoBind,
 			"/run/systemd/resolve/stub-resolv.conf",
 			"/run/systemd/resolve/stub-resolv.conf",
-		"--bind",
+		),
+		bindMount(
+			mountBind,
 			filepath.Join(xdgDir.runtimeDir, "systemd/notify"),
 			filepath.Join(xdgDir.runtimeDir, "systemd/notify"),
-		"--ro-bind-try",
+		),
+		tryMount(
+			mountRoBind,
 			filepath.Join(xdgDir.runtimeDir, "pulse"),
 			filepath.Join(xdgDir.runtimeDir, "pulse"),
+		),
 
 		// HOME binds
-		"--bind",
-			filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory),
+		bindMount(
+			mountBind,
+			stateSource(config),
 			xdgDir.home,
-		"--bind",
-			filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory),
+		),
+		bindMount(
+			mountBind,
+			stateSource(config),
 			filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory),
+		),
 
-		"--ro-bind",		"/etc", "/etc",
-		"--ro-bind-try",	filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "passwd"), "/etc/passwd",
-		"--ro-bind-try",	filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "nsswitch"), "/etc/nsswitch.conf",
-
-		// Privacy mounts
-		"--tmpfs",		"/proc/1",
-		"--tmpfs",		"/usr/share/applications",
-		"--tmpfs",		filepath.Join(xdgDir.home, "options"),
-		"--tmpfs",		filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory, "options"),
-	}
+		tryMount(mountRoBind, filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "passwd"), "/etc/passwd"),
+		tryMount(mountRoBind, filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "nsswitch"), "/etc/nsswitch.conf"),
+		tryMount(mountRoBind, filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "group"), "/etc/group"),
+		tryMount(mountRoBind, filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "gshadow"), "/etc/gshadow"),
+	}
+	mountChan <- etcBinds(config)
+	mountChan <- identityBinds(config)
+	// Privacy mounts
+	mountChan <- withPhase([]Mount{
+		tryMount(mountRoBind, "/dev/null", "/proc/uptime"),
+		tryMount(mountRoBind, "/dev/null", "/proc/modules"),
+		tryMount(mountRoBind, "/dev/null", "/proc/cmdline"),
+		tryMount(mountRoBind, "/dev/null", "/proc/diskstats"),
+		tryMount(mountRoBind, "/dev/null", "/proc/devices"),
+		tryMount(mountRoBind, "/dev/null", "/proc/config.gz"),
+		tryMount(mountRoBind, "/dev/null", "/proc/loadavg"),
+		tmpfsMount("/proc/1"),
+		tmpfsMount("/usr/share/applications"),
+		tmpfsMount(filepath.Join(xdgDir.home, "options")),
+		tmpfsMount(filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory, "options")),
+	}, mountPhasePrivacy)
 	wg.Go(func() {
+		overlay := []string{
+			"/usr/bin",
+			"/usr/lib/portable/overlay-usr",
+		}
 		if config.Exec.Overlay {
-			argChan <- []string{
-				"--overlay-src",	"/usr/bin",
-				"--overlay-src",	"/usr/lib/portable/overlay-usr",
-				"--overlay-src",	filepath.Join(
-								"/usr/lib/portable/info",
-								config.Metadata.AppID,
-								"/bin",
-							),
-				"--ro-overlay",		"/usr/bin",
-			}
-		} else {
-			argChan <- []string{
-				"--overlay-src",	"/usr/bin",
-				"--overlay-src",	"/usr/lib/portable/overlay-usr",
-				"--ro-overlay",		"/usr/bin",
-			}
+			overlay = append(overlay, filepath.Join(
+				"/usr/lib/portable/info",
+				installedAppID(config),
+				"/bin",
+			))
+		}
+		mountChan <- []Mount{
+			{Kind: mountRoOverlay, Dest: "/usr/bin", Overlay: overlay},
 		}
 	})
 	wg.Go(func() {
@@ -1605,54 +1656,72 @@ func genBwArg(
 			if err != nil {
 				pecho("warn", "Could not expose virtualisation node:", err)
 			} else {
-				argChan <- []string{
-					"--dev-bind",
-						"/dev/kvm",
-						"/dev/kvm",
+				mountChan <- []Mount{
+					{Kind: mountDevBind, Phase: mountPhaseDevice, Src: "/dev/kvm", Dest: "/dev/kvm"},
 				}
 			}
 		}
 	})
 	wg.Go(func() {
-		for arg := range gpuChan {
-			argChan <- arg
+		for mnt := range gpuChan {
+			mountChan <- withPhase(mnt, mountPhaseDevice)
 		}
 	})
 	wg.Go(func() {
-		for arg := range xChan {
-			argChan <- arg
+		for mnt := range xChan {
+			mountChan <- mnt
 		}
 	})
 	wg.Go(func() {
-		for arg := range miscChan {
-			argChan <- arg
+		for mnt := range miscChan {
+			mountChan <- mnt
 		}
 	})
 	wg.Go(func() {
 		if slices.Contains(config.System.DeviceAllow, "input") {
-			var inputArgs []string
-			for inputArg := range inputChan {
-				inputArgs = append(inputArgs, inputArg...)
+			var inputMounts []Mount
+			for mnt := range inputChan {
+				inputMounts = append(inputMounts, mnt...)
 			}
-			go pecho("debug", "Generated Input Bind arguments:", inputArgs)
-			argChan <- inputArgs
+			go pecho("debug", "Generated Input Bind arguments:", inputMounts)
+			mountChan <- withPhase(inputMounts, mountPhaseDevice)
 		}
 	})
 	wg.Go(func() {
-		for arg := range camChan {
-			argChan <- arg
+		for mnt := range camChan {
+			mountChan <- withPhase(mnt, mountPhaseDevice)
 		}
 	})
 
 	select {
-		case wayArgs := <- wayDisplayChan:
-			argChan <- wayArgs
+		case wayMounts := <- wayDisplayChan:
+			mountChan <- wayMounts
 		default:
 			pecho("warn", "Could not find a working Wayland socket: either the compositor is not started, or WAYLAND_DISPLAY is pointed to a wrong address")
 	}
 	wg.Wait()
+	close(mountChan)
+	mountWg.Wait()
 
+	mountArgs, layout, err := mounts.args()
+	if err != nil {
+		pecho("crit", "Invalid sandbox layout:", err)
+		select {}
+	}
+	hash := layoutHash(layout, runtimeInfo.instanceID)
+	runtimeInfo.layoutHash = hash
+	runtimeInfo.deviceNodes = deviceNodes(layout)
+	pecho("debug", "Sandbox layout hash: " + hash)
+	argsPath, err := writeBwrapArgs(append(bwrapOpts, mountArgs...))
+	if err != nil {
+		pecho("crit", "Could not write bwrap arguments:", err)
+	}
+	// systemd passes files of OpenFile= starting from file descriptor 3
 	argChan <- []string{
+		"-p", "OpenFile=" + argsPath + ":bwrap-args:read-only",
+		"--",
+		"bwrap",
+		"--args", "3",
 		"--",
 		"/usr/lib/portable/helper/helper",
 	}
@@ -1670,6 +1739,9 @@ func flushEnvs(config Config) {
 	for env := range envsChan {
 		builder.WriteString(env)
 		builder.WriteString("\n")
+		if key, _, ok := strings.Cut(env, "="); ok {
+			generatedEnvKeys[strings.TrimSpace(key)] = true
+		}
 	}
 
 	fd, err := os.OpenFile(
@@ -1743,21 +1815,21 @@ func questionExpose(paths []string, config Config) bool {
 	return true
 }
 
-func maskDir(path string) (maskArgs []string) {
+func maskDir(path string) (maskMounts []Mount) {
 	maskT, err := os.Stat(path)
 	if err == nil && maskT.IsDir() == true {
 		pecho("debug", "Masking " + path)
 	} else {
 		return
 	}
-	maskArgs = append(
-		maskArgs,
-		"--tmpfs", path,
+	maskMounts = append(
+		maskMounts,
+		Mount{Kind: mountTmpfs, Phase: mountPhaseMask, Dest: path},
 	)
 	return
 }
 
-func miscBinds(miscChan chan []string, pwChan chan []string, config Config, exposeChan chan map[string]string, docMap chan PassFiles) {
+func miscBinds(miscChan chan []Mount, pwChan chan []Mount, config Config, exposeChan chan map[string]string, docMap chan PassFiles) {
 
 	defer close(docMap)
 	var wg sync.WaitGroup
@@ -1783,115 +1855,74 @@ func miscBinds(miscChan chan []string, pwChan chan []string, config Config, expo
 	wg.Go(func() {
 		_, err := os.Stat("/usr/lib/flatpak-xdg-utils/flatpak-spawn")
 		if err == nil {
-			miscChan <- []string{
-				"--ro-bind",
-				"/usr/lib/portable/overlay-usr/flatpak-spawn",
-				"/usr/lib/flatpak-xdg-utils/flatpak-spawn",
+			miscChan <- []Mount{
+				bindMount(
+					mountRoBind,
+					"/usr/lib/portable/overlay-usr/flatpak-spawn",
+					"/usr/lib/flatpak-xdg-utils/flatpak-spawn",
+				),
 			}
 		}
 	})
 
-	wg.Go(func() {
-		miscChan <- maskDir("/etc/kernel")
-	})
-
-	wg.Go(func() {
-		miscChan <- []string{
-			"--ro-bind-try",
-			xdgDir.confDir + "/fontconfig",
-			translatePath(filepath.Join(xdgDir.confDir, "fontconfig"), config),
-		}
-	})
-
-	wg.Go(func() {
-		miscChan <- []string {
-			"--ro-bind-try",
-			xdgDir.confDir + "/gtk-3.0/gtk.css",
-			translatePath(xdgDir.confDir + "/gtk-3.0/gtk.css", config),
-			"--ro-bind-try",
-			xdgDir.confDir + "/gtk-3.0/noctalia.css",
-			translatePath(xdgDir.confDir + "/gtk-3.0/noctalia.css", config),
-		}
-	})
-
-	wg.Go(func() {
-		miscChan <- []string {
-			"--ro-bind-try",
-			xdgDir.confDir + "/gtk-3.0/colors.css",
-			translatePath(xdgDir.confDir + "/gtk-3.0/colors.css", config),
-		}
-	})
-
-	wg.Go(func() {
-		miscChan <- []string {
-			"--ro-bind-try",
-			xdgDir.confDir + "/gtk-4.0/gtk.css",
-			translatePath(xdgDir.confDir + "/gtk-4.0/gtk.css", config),
-		}
-	})
-
-	wg.Go(func() {
-		miscChan <- []string{
-			"--ro-bind-try",
-			filepath.Join(xdgDir.confDir, "kdeglobals"),
-			translatePath(
-				filepath.Join(xdgDir.confDir, "kdeglobals"),
-				config,
-			),
-		}
-	})
+	if ! etcMinimal(config) {
+		wg.Go(func() {
+			miscChan <- maskDir("/etc/kernel")
+		})
+	}
 
 	wg.Go(func() {
-		miscChan <- []string{
-			"--ro-bind-try",
-			xdgDir.confDir + "/qt6ct",
-			translatePath(xdgDir.confDir + "/qt6ct", config),
-		}
+		miscChan <- localtimeBind(config)
 	})
 
 	wg.Go(func() {
-		miscChan <- []string{
-			"--ro-bind-try",
-			xdgDir.dataDir + "/fonts",
-			translatePath(xdgDir.dataDir + "/fonts", config),
-		}
+		miscChan <- themeBinds(config)
 	})
 
 	wg.Go(func() {
-		miscChan <- []string{
-			"--ro-bind-try",
-			xdgDir.dataDir + "/icons",
-			translatePath(xdgDir.dataDir + "/icons", config),
-		}
+		miscChan <- profilesMask(config)
 	})
 
 	if config.Advanced.FlatpakInfo {
-		miscChan <- []string{
-			"--ro-bind",
+		miscChan <- []Mount{
+			bindMount(
+				mountRoBind,
 				"/dev/null",
 				xdgDir.runtimeDir + "/.flatpak/" + runtimeInfo.instanceID + "-private/run-environ",
-			"--ro-bind",
+			),
+			bindMount(
+				mountRoBind,
 				xdgDir.runtimeDir + "/.flatpak/" + runtimeInfo.instanceID,
 				xdgDir.runtimeDir + "/.flatpak/" + runtimeInfo.instanceID,
-			"--ro-bind",
+			),
+			bindMount(
+				mountRoBind,
 				xdgDir.runtimeDir + "/.flatpak/" + runtimeInfo.instanceID,
 				xdgDir.runtimeDir + "/flatpak-runtime-directory",
-			"--ro-bind",
+			),
+			bindMount(
+				mountRoBind,
 				xdgDir.runtimeDir + "/portable/" + config.Metadata.AppID + "/flatpak-info",
 				"/.flatpak-info",
-			"--ro-bind",
+			),
+			bindMount(
+				mountRoBind,
 				xdgDir.runtimeDir + "/portable/" + config.Metadata.AppID + "/flatpak-info",
 				xdgDir.runtimeDir + "/.flatpak-info",
-			"--ro-bind",
+			),
+			bindMount(
+				mountRoBind,
 				xdgDir.runtimeDir + "/portable/" + config.Metadata.AppID + "/flatpak-info",
 				xdgDir.dataDir + "/" + config.Metadata.StateDirectory + "/.flatpak-info",
-			"--tmpfs",		xdgDir.home + "/.var",
-			"--tmpfs",		xdgDir.dataDir + "/" + config.Metadata.StateDirectory + "/.var",
-			"--bind",
-				xdgDir.dataDir + "/" + config.Metadata.StateDirectory,
+			),
+			tmpfsMount(xdgDir.home + "/.var"),
+			tmpfsMount(xdgDir.dataDir + "/" + config.Metadata.StateDirectory + "/.var"),
+			bindMount(
+				mountBind,
+				stateSource(config),
 				xdgDir.dataDir + "/" + config.Metadata.StateDirectory + "/.var/app/" + config.Metadata.AppID,
-			"--tmpfs",
-				xdgDir.dataDir + "/" + config.Metadata.StateDirectory + "/.var/app/" + config.Metadata.AppID + "/options",
+			),
+			tmpfsMount(xdgDir.dataDir + "/" + config.Metadata.StateDirectory + "/.var/app/" + config.Metadata.AppID + "/options"),
 		}
 	}
 
@@ -1902,12 +1933,12 @@ func miscBinds(miscChan chan []string, pwChan chan []string, config Config, expo
 	close(miscChan)
 }
 
-func bindXAuth(xauthChan chan []string, config Config) {
+func bindXAuth(xauthChan chan []Mount, config Config) {
 	defer close(xauthChan)
 	if config.Privacy.X11 {
-		xauthChan <- []string{
-			"--bind-try",		"/tmp/.X11-unix", "/tmp/.X11-unix",
-			"--bind-try",		"/tmp/.XIM-unix", "/tmp/.XIM-unix",
+		xauthChan <- []Mount{
+			tryMount(mountBind, "/tmp/.X11-unix", "/tmp/.X11-unix"),
+			tryMount(mountBind, "/tmp/.XIM-unix", "/tmp/.XIM-unix"),
 		}
 		osAuth := os.Getenv("XAUTHORITY")
 		osAuth, err := filepath.Abs(osAuth)
@@ -1917,10 +1948,8 @@ func bindXAuth(xauthChan chan []string, config Config) {
 			_, err := os.Stat(osAuth)
 			if err == nil {
 				pecho("debug", "XAUTHORITY specified as absolute path: " + osAuth)
-				xauthChan <- []string {
-					"--ro-bind",
-					osAuth,
-					"/run/.Xauthority",
+				xauthChan <- []Mount{
+					bindMount(mountRoBind, osAuth, "/run/.Xauthority"),
 				}
 				addEnv("XAUTHORITY=/run/.Xauthority")
 				addEnv("DISPLAY=" + os.Getenv("DISPLAY"))
@@ -1934,10 +1963,8 @@ func bindXAuth(xauthChan chan []string, config Config) {
 				"warn",
 				"Implied XAUTHORITY " + osAuth + ", this is not recommended",
 			)
-			xauthChan <- []string{
-				"--ro-bind",
-				osAuth,
-				"/run/.Xauthority",
+			xauthChan <- []Mount{
+				bindMount(mountRoBind, osAuth, "/run/.Xauthority"),
 			}
 			addEnv("XAUTHORITY=/run/.Xauthority")
 			addEnv("DISPLAY=" + os.Getenv("DISPLAY"))
@@ -1948,7 +1975,7 @@ func bindXAuth(xauthChan chan []string, config Config) {
 	}
 }
 
-func tryBindCam(camChan chan []string, config Config) {
+func tryBindCam(camChan chan []Mount, config Config) {
 	defer close(camChan)
 	if slices.Contains(config.System.DeviceAllow, "camera") {
 		camArg, err := GenerateCameraBindArgs()
@@ -1960,8 +1987,8 @@ func tryBindCam(camChan chan []string, config Config) {
 	}
 }
 
-func tryBindNv() []string {
-	nvDevsArg := []string{}
+func tryBindNv() []Mount {
+	nvDevsArg := []Mount{}
 	devEntries, err := os.ReadDir("/dev")
 	if err != nil {
 		pecho("warn", "Failed to read /dev: " + err.Error())
@@ -1970,9 +1997,11 @@ func tryBindNv() []string {
 			if strings.HasPrefix(devFile.Name(), "nvidia") {
 				nvDevsArg = append(
 					nvDevsArg,
-					"--dev-bind",
+					bindMount(
+						mountDevBind,
 						"/dev/" + devFile.Name(),
 						"/dev/" + devFile.Name(),
+					),
 				)
 			}
 		}
@@ -2047,6 +2076,9 @@ func atSpiProxy(conn *godbus.Conn, config Config) {
 	atSpiProxyCmd.Start()
 }
 func main() {
+	if os.Getenv(watchdogFeederEnv) == "1" {
+		runWatchdogFeeder()
+	}
 	var stopSignal = make(chan int)
 	exposeChan := make(chan map[string]string, 16)
 	miChan := make(chan bool, 1)
@@ -2056,12 +2088,15 @@ func main() {
 	var config Config
 	wg.Go(func() {
 		config = getConf()
+		applyProfile(&config, os.Args[1:])
+		applyEphemeral(&config, os.Args[1:])
 	})
 	sigChan := make(chan os.Signal, 1)
 
 	go signalRecvWorker(sigChan, stopSignal)
+	setLogField("PHASE", "startup")
 	go pechoWorker(stopSignal)
-	wayDisplayChan := make(chan[]string, 1)
+	wayDisplayChan := make(chan []Mount, 1)
 
 	var sdContext context.Context
 	var sdCancelFunc context.CancelFunc
@@ -2079,11 +2114,12 @@ func main() {
 
 
 
-	inputChan := make(chan []string, 4)
+	inputChan := make(chan []Mount, 4)
 	go inputBind(inputChan) // This is fine, since genBwArg takes care of conf switching
 	pecho("info", "Portable daemon", version)
 	cmdChan := make(chan int8, 1)
 	wg.Wait()
+	setLogField("APPID", config.Metadata.AppID)
 
 	var mkdirWg sync.WaitGroup
 	var mntWg sync.WaitGroup
@@ -2132,7 +2168,7 @@ func main() {
 						)
 					}
 				})
-				stopFuncChan <- func() {
+				addStopHook(stageBusName, func() {
 					if busConn == nil {
 						pecho(
 							"warn",
@@ -2159,7 +2195,7 @@ func main() {
 							"Could not release D-Bus name:", reply,
 						)
 					}
-				}
+				})
 				mkdirWg.Go(func() {
 					var dirs = []string{
 						filepath.Join(
@@ -2184,6 +2220,11 @@ func main() {
 							".flatpak",
 							runtimeInfo.instanceID,
 						),
+						filepath.Join(
+							xdgDir.runtimeDir,
+							".flatpak",
+							runtimeInfo.instanceID + "-private",
+						),
 						filepath.Join(
 							xdgDir.runtimeDir,
 							"app",
@@ -2199,12 +2240,12 @@ func main() {
 								pecho("crit", "Could not create directory:", err)
 								return
 							}
-							stopFuncChan <- func() {
+							addStopHook(stageRuntimeDir, func() {
 								err := os.RemoveAll(pth)
 								if err != nil {
 									pecho("warn", "Could not remove directory:", err)
 								}
-							}
+							})
 						})
 					}
 					err = os.MkdirAll(
@@ -2237,14 +2278,16 @@ func main() {
 
 	go cmdlineDispatcher(cmdChan, &config, exposeChan)
 	go gpuBind(gpuChan, config)
-	miscChan := make(chan []string, 10240)
-	pwSecContextChan := make(chan []string, 1)
+	miscChan := make(chan []Mount, 10240)
+	pwSecContextChan := make(chan []Mount, 1)
 
 	wg.Go(func() {
 		instDesktopFile(config)
 	})
 	wg.Go(func() {
-		setupSharedDir(config)
+		if ! config.ephemeral {
+			setupSharedDir(config)
+		}
 	})
 	genChan := make(chan int8, 2) /* Signals when an ID has been chosen,
 		and we signal back when multi-instance is cleared
@@ -2253,17 +2296,14 @@ func main() {
 	wg.Add(1)
 	go func () {
 		defer wg.Done()
-		genInstanceID(genChan, genChanProceed, config)
+		genInstanceID(conn, genChan, genChanProceed, config)
 	} ()
-	xChan := make(chan []string, 1)
+	xChan := make(chan []Mount, 1)
 	go bindXAuth(xChan, config)
-	camChan := make(chan []string, 1)
+	camChan := make(chan []Mount, 1)
 	go tryBindCam(camChan, config)
 
 	<- cmdChan
-	docsMap := make(chan PassFiles, 1)
-	go miscBinds(miscChan, pwSecContextChan, config, exposeChan, docsMap)
-
 	abortChan <- false
 	if abort := <- abortChan; abort {
 		pecho("warn", "Aborting start sequence")
@@ -2271,11 +2311,30 @@ func main() {
 	}
 
 	// This also needs to wait before cmdChan for debug-shell
-	if multiInstanceDetected := <- miChan; multiInstanceDetected {
+	multiInstanceDetected := <- miChan
+	if multiInstanceDetected {
+		exposeLive(config, runtimeOpt.exposeMap)
+	} else if len(runtimeOpt.exposeMap) > 0 {
+		exposeChan <- runtimeOpt.exposeMap
+	}
+	docsMap := make(chan PassFiles, 1)
+	go miscBinds(miscChan, pwSecContextChan, config, exposeChan, docsMap)
+
+	if multiInstanceDetected && runtimeOpt.headless {
+		pecho("info", "Application already running, skipping headless launch")
+		return
+	} else if multiInstanceDetected {
 		wakeInstance(config, docsMap)
 	} else {
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
 	mkdirWg.Wait()
+	resetEphemeralOnStop(config)
+	wg.Go(func() {
+		err := setupEphemeralHome(config)
+		if err != nil {
+			pecho("crit", "Could not set up ephemeral home:", err)
+		}
+	})
 	wg.Go(func() {
 		// must run after cmdline dispatcher for debug shell!
 		prepareEnvs(config)
@@ -2319,7 +2378,18 @@ func main() {
 	go pwSecContext(pwSecContextChan, config)
 	wg.Wait()
 	close(envsChan)
-	startApp(config, bwArgChan, stopSignal)
+	err := runHooks(config, "preStart", config.Hooks.PreStart, "")
+	if err != nil {
+		pecho("crit", "Could not start application:", err)
+		select {}
+	}
+	preStartDone.Store(true)
+	go auditStartup(config)
+	go idleWatcher(conn, config, stopSignal)
+	go pingWatchdog(conn, config)
+	go unitWatchdog(conn, config)
+	go applyConfigSchedule(conn, config)
+	startApp(conn, config, bwArgChan, stopSignal)
 	select {}
 	}
 }
\ No newline at end of file
@@ -24,6 +24,18 @@ func setDefaultConfOpts() Config {
 	config.Processes.Background = true
 	config.Network.Enable = true
 	config.Processes.Track = true
+	config.Processes.StopTimeout = 10
+	config.Processes.Restart = "no"
+	config.Processes.RestartDelay = 1
+	config.Processes.RestartBurst = 5
+	config.Processes.Watchdog = "unit"
+	config.Processes.WatchdogMisses = 3
+	config.Processes.WatchdogAction = "stop"
+	config.Hooks.Timeout = 30
+	config.Schedule.Mode = "headless"
+	config.Privacy.InputMethod = "auto"
+	config.Privacy.Etc = "full"
+	config.Theme.Profiles = themeProfilesDefault
 	config.Privacy.ClassicNotifications = true
 	config.Advanced.Qt5Compat = true
 	config.Advanced.FlatpakInfo = true
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Host variables passed into the sandbox, anything else is blocked. Entries may use * wildcards
+var envAllowBuiltin = []string{
+	"PATH",
+	"USER",
+	"LOGNAME",
+	"SHELL",
+	"LANG",
+	"LANGUAGE",
+	"LC_*",
+	"TZ",
+	"XDG_CURRENT_DESKTOP",
+	"XDG_SESSION_DESKTOP",
+	"XDG_DATA_DIRS",
+	"XDG_CONFIG_DIRS",
+	"XDG_MENU_PREFIX",
+	"DESKTOP_SESSION",
+	"XDG_ACTIVATION_TOKEN",
+	"DESKTOP_STARTUP_ID",
+	"QT_SCALE_FACTOR",
+	"GDK_SCALE",
+	"GDK_DPI_SCALE",
+	"XCURSOR_THEME",
+	"XCURSOR_SIZE",
+	"XMODIFIERS",
+	"XKB_DEFAULT_*",
+	"QT_QPA_PLATFORM",
+	"GDK_BACKEND",
+	"ELECTRON_OZONE_PLATFORM_HINT",
+	"SDL_VIDEODRIVER",
+	"NO_AT_BRIDGE",
+	"COLORTERM",
+}
+
+// Host variables never passed into the sandbox, even if allowed in configuration
+var envDenyBuiltin = []string{
+	"GNOME_SETUP_DISPLAY",
+	"PIPEWIRE_REMOTE",
+	"PAM_KWALLET5_LOGIN",
+	"GTK2_RC_FILES",
+	"ICEAUTHORITY",
+	"MANAGERPID",
+	"INVOCATION_ID",
+	"MANAGERPIDFDID",
+	"SSH_AUTH_SOCK",
+	"SSH_AGENT_PID",
+	"GPG_AGENT_INFO",
+	"VK_LOADER_DRIVERS_DISABLE",
+	"MAIL",
+	"SYSTEMD_EXEC_PID",
+	"*SECRET*",
+	"*PASSWORD*",
+	"*_API_KEY",
+}
+
+// Keys written to generated.env, set by flushEnvs before envsFlushReady is closed
+var generatedEnvKeys = map[string]bool{}
+
+func envMatches(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a host variable may reach the sandbox, deny lists take precedence
+func envAllowed(config Config, name string) bool {
+	if localeOverridden(config, name) {
+		return false
+	}
+	if envMatches(envDenyBuiltin, name) || envMatches(config.Environment.Deny, name) {
+		return false
+	}
+	return envMatches(envAllowBuiltin, name) || envMatches(config.Environment.Allow, name)
+}
+
+func parseEnvList(list []string) map[string]string {
+	ret := make(map[string]string, len(list))
+	for _, line := range list {
+		key, val, ok := strings.Cut(line, "=")
+		if ok {
+			ret[key] = val
+		}
+	}
+	return ret
+}
+
+// Reads the environment of the user service manager, which units inherit
+func managerEnvironment() (map[string]string, error) {
+	conn, err := godbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+	obj := conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	variant, err := obj.GetProperty("org.freedesktop.systemd1.Manager.Environment")
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	err = variant.Store(&list)
+	if err != nil {
+		return nil, err
+	}
+	return parseEnvList(list), nil
+}
+
+// Forwards allowed variables of the launching environment, which may differ from the manager
+func passthroughEnvs(config Config) {
+	for key, val := range parseEnvList(os.Environ()) {
+		if envAllowed(config, key) {
+			addEnv(key + "=" + val)
+		}
+	}
+}
+
+// Arguments for systemd-run unsetting manager variables not allowed by policy
+func envUnsetArgs(config Config) []string {
+	managerEnv, err := managerEnvironment()
+	if err != nil {
+		pecho("warn", "Could not read environment of the service manager:", err)
+	}
+	return envUnsetArgsFor(config, managerEnv)
+}
+
+func envUnsetArgsFor(config Config, managerEnv map[string]string) []string {
+	var names []string
+	for key := range managerEnv {
+		if ! envAllowed(config, key) {
+			names = append(names, key)
+		}
+	}
+	for _, list := range [][]string{envDenyBuiltin, config.Environment.Deny} {
+		for _, name := range list {
+			if ! strings.Contains(name, "*") {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	names = slices.Compact(names)
+	var args []string
+	for _, name := range names {
+		if generatedEnvKeys[name] {
+			continue
+		}
+		args = append(args, "-p", "UnsetEnvironment=" + name)
+	}
+	return args
+}
+
+// Handles --dry-run, lists which host variables would reach the sandbox
+func printEnvPolicy(config Config) {
+	vars := parseEnvList(os.Environ())
+	managerEnv, err := managerEnvironment()
+	if err != nil {
+		pecho("warn", "Could not read environment of the service manager:", err)
+	}
+	for key, val := range managerEnv {
+		if _, ok := vars[key]; ! ok {
+			vars[key] = val
+		}
+	}
+	var names []string
+	for key := range vars {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	var builder strings.Builder
+	builder.WriteString("Host environment variables: \n")
+	for _, name := range names {
+		if envAllowed(config, name) {
+			builder.WriteString("	pass	" + name + "\n")
+		} else {
+			builder.WriteString("	block	" + name + "\n")
+		}
+	}
+	builder.WriteString("Variables set by Portable itself override host values.\n")
+	fmt.Print(builder.String())
+}
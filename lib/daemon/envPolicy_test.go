@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvAllowed(t *testing.T) {
+	var config Config
+	config.Environment.Allow = []string{"MOZ_*", "GITHUB_TOKEN"}
+	config.Environment.Deny = []string{"MOZ_CRASHREPORTER_URL"}
+	for name, want := range map[string]bool{
+		"PATH":				true,
+		"XDG_ACTIVATION_TOKEN":		true,
+		"MOZ_ENABLE_WAYLAND":		true,
+		"MOZ_CRASHREPORTER_URL":	false,
+		"SSH_AUTH_SOCK":		false,
+		"AWS_SECRET_ACCESS_KEY":	false,
+		"OPENAI_API_KEY":		false,
+		"GITHUB_TOKEN":			true,
+		"SOME_NEW_VARIABLE":		false,
+		"LC_TIME":			true,
+	} {
+		if got := envAllowed(config, name); got != want {
+			t.Error("envAllowed(" + name + ") returned", got)
+		}
+	}
+
+	config.Locale.Language = "en_US.UTF-8"
+	config.Locale.Timezone = "Asia/Shanghai"
+	for _, name := range []string{"LANG", "LANGUAGE", "LC_TIME", "TZ"} {
+		if envAllowed(config, name) {
+			t.Error("Overridden variable " + name + " must not pass through")
+		}
+	}
+}
+
+func TestEnvUnsetArgs(t *testing.T) {
+	oldGenerated := generatedEnvKeys
+	generatedEnvKeys = map[string]bool{"HOME": true}
+	t.Cleanup(func() {
+		generatedEnvKeys = oldGenerated
+	})
+
+	var config Config
+	config.Environment.Deny = []string{"MOZ_*", "XDG_MENU_PREFIX"}
+	managerEnv := map[string]string{
+		"PATH":			"/usr/bin",
+		"XDG_DATA_DIRS":	"/usr/local/share:/usr/share",
+		"XDG_CONFIG_DIRS":	"/etc/xdg",
+		"XMODIFIERS":		"@im=fcitx",
+		"HOME":			"/home/user",
+		"SSH_AUTH_SOCK":	"/run/user/1000/ssh-agent",
+		"MOZ_ENABLE_WAYLAND":	"1",
+		"SOME_NEW_VARIABLE":	"1",
+	}
+	got := envUnsetArgsFor(config, managerEnv)
+	unset := map[string]bool{}
+	for idx := 0; idx + 1 < len(got); idx += 2 {
+		if got[idx] != "-p" || ! strings.HasPrefix(got[idx + 1], "UnsetEnvironment=") {
+			t.Fatal("Malformed arguments:", got)
+		}
+		unset[strings.TrimPrefix(got[idx + 1], "UnsetEnvironment=")] = true
+	}
+	for name, want := range map[string]bool{
+		"PATH":			false,
+		"XDG_DATA_DIRS":	false,
+		"XDG_CONFIG_DIRS":	false,
+		"XMODIFIERS":		false,
+		"HOME":			false,
+		"SSH_AUTH_SOCK":	true,
+		"MOZ_ENABLE_WAYLAND":	true,
+		"SOME_NEW_VARIABLE":	true,
+		// Denied even if the manager does not have it
+		"GPG_AGENT_INFO":	true,
+		"XDG_MENU_PREFIX":	true,
+		"MOZ_*":		false,
+	} {
+		if unset[name] != want {
+			t.Error("Variable", name, "unset:", unset[name])
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Appended to the application ID of ephemeral instances, giving them their own bus name and runtime directories
+const ephemeralSuffix = ".Ephemeral"
+
+// Scans the command line for --ephemeral and --ephemeral-snapshot.
+// They change the application ID, so they are handled before anything uses the configuration
+func ephemeralRequested(args []string) (ephemeral bool, snapshot bool) {
+	for _, arg := range args {
+		switch arg {
+			case "--":
+				return
+			case "--ephemeral":
+				ephemeral = true
+			case "--ephemeral-snapshot":
+				ephemeral = true
+				snapshot = true
+		}
+	}
+	return
+}
+
+func applyEphemeral(config *Config, args []string) {
+	ephemeral, snapshot := ephemeralRequested(args)
+	if ! ephemeral {
+		return
+	}
+	config.ephemeral = true
+	config.ephemeralSnapshot = snapshot
+	if len(config.baseAppID) == 0 {
+		config.baseAppID = config.Metadata.AppID
+	}
+	config.Metadata.AppID = config.Metadata.AppID + ephemeralSuffix
+	// Scheduled launches belong to the regular instance
+	config.Schedule.Calendar = nil
+}
+
+// Application ID the configuration is installed as, without profile or ephemeral suffixes
+func installedAppID(config Config) string {
+	if len(config.baseAppID) > 0 {
+		return config.baseAppID
+	}
+	return config.Metadata.AppID
+}
+
+// Host directory backing the home directory of the sandbox. Ephemeral instances use one in the
+// private instance directory, on the tmpfs of XDG_RUNTIME_DIR, which is removed on stop
+func stateSource(config Config) string {
+	if config.ephemeral {
+		return filepath.Join(xdgDir.runtimeDir, ".flatpak", runtimeInfo.instanceID + "-private", "home")
+	}
+	return filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory)
+}
+
+// Creates the home directory of an ephemeral instance, copied from the real one for snapshots
+func setupEphemeralHome(config Config) error {
+	if ! config.ephemeral {
+		return nil
+	}
+	home := stateSource(config)
+	err := os.MkdirAll(home, 0700)
+	if err != nil {
+		return err
+	}
+	if config.ephemeralSnapshot {
+		src := filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory)
+		_, err := os.Stat(src)
+		if err == nil {
+			pecho("info", "Copying state directory into ephemeral home")
+			cpCmd := exec.Command("cp", "-a", "--reflink=auto", "--", src + "/.", home)
+			cpCmd.Stderr = os.Stderr
+			err = cpCmd.Run()
+			if err != nil {
+				return errors.New("Could not copy state directory: " + err.Error())
+			}
+		} else if ! os.IsNotExist(err) {
+			return err
+		}
+	}
+	setupSharedDir(config)
+	return nil
+}
+
+// Tables of the permission store, which keeps one database file per table
+func permissionTables() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(xdgDir.dataDir, "flatpak", "db"))
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			tables = append(tables, entry.Name())
+		}
+	}
+	return tables, nil
+}
+
+// Removes the permissions of appID from every permission store table, like flatpak permission-reset
+func resetPermissions(conn *godbus.Conn, appID string) {
+	tables, err := permissionTables()
+	if err != nil {
+		if ! os.IsNotExist(err) {
+			pecho("warn", "Could not list permission tables:", err)
+		}
+		return
+	}
+	obj := conn.Object("org.freedesktop.impl.portal.PermissionStore", "/org/freedesktop/impl/portal/PermissionStore")
+	for _, table := range tables {
+		var ids []string
+		err := obj.Call("org.freedesktop.impl.portal.PermissionStore.List", 0, table).Store(&ids)
+		if err != nil {
+			pecho("debug", "Could not list permission table " + table + ":", err)
+			continue
+		}
+		for _, id := range ids {
+			var perms map[string][]string
+			var data godbus.Variant
+			err := obj.Call("org.freedesktop.impl.portal.PermissionStore.Lookup", 0, table, id).Store(&perms, &data)
+			if err != nil {
+				continue
+			}
+			if _, ok := perms[appID]; ! ok {
+				continue
+			}
+			call := obj.Call("org.freedesktop.impl.portal.PermissionStore.DeletePermission", 0, table, id, appID)
+			if call.Err != nil {
+				pecho("warn", "Could not reset permission " + table + "/" + id + ":", call.Err)
+			}
+		}
+	}
+}
+
+// Discards what portals remember about an ephemeral instance once it stops
+func resetEphemeralOnStop(config Config) {
+	if ! config.ephemeral {
+		return
+	}
+	addStopHook(stageDocuments, func() {
+		conn, err := godbus.SessionBus()
+		if err != nil {
+			pecho("warn", "Could not connect to session bus, permissions are kept:", err)
+			return
+		}
+		resetPermissions(conn, config.Metadata.AppID)
+	})
+}
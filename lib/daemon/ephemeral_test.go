@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyEphemeral(t *testing.T) {
+	var config Config
+	config.Metadata.AppID = "org.example.App"
+	config.Schedule.Calendar = []string{"hourly"}
+	applyEphemeral(&config, []string{"--", "--ephemeral"})
+	if config.ephemeral {
+		t.Error("Application argument enabled ephemeral mode")
+	}
+
+	applyEphemeral(&config, []string{"--expose", "/tmp/a", "/tmp/a", "--ephemeral-snapshot"})
+	if ! config.ephemeral || ! config.ephemeralSnapshot {
+		t.Error("--ephemeral-snapshot not applied")
+	}
+	if config.Metadata.AppID != "org.example.App.Ephemeral" {
+		t.Error("Unexpected application ID " + config.Metadata.AppID)
+	}
+	if installedAppID(config) != "org.example.App" {
+		t.Error("Unexpected installed application ID " + installedAppID(config))
+	}
+	if len(config.Schedule.Calendar) > 0 {
+		t.Error("Ephemeral instance kept schedules")
+	}
+}
+
+func TestEphemeralProxyOwnArgs(t *testing.T) {
+	var config Config
+	config.Metadata.AppID = "org.example.App"
+	config.Advanced.MprisName = []string{"Player"}
+	applyEphemeral(&config, []string{"--ephemeral"})
+	args := proxyOwnArgs(config)
+	want := []string{
+		"--own=org.example.App.Ephemeral",
+		"--own=org.example.App.Ephemeral.*",
+		"--own=org.mpris.MediaPlayer2.org.example.App.Ephemeral",
+		"--own=org.mpris.MediaPlayer2.org.example.App.Ephemeral.*",
+		"--own=org.mpris.MediaPlayer2.App.Ephemeral",
+		"--own=org.mpris.MediaPlayer2.App.Ephemeral.*",
+		"--own=org.mpris.MediaPlayer2.Player.Ephemeral",
+		"--own=org.mpris.MediaPlayer2.Player.Ephemeral.*",
+	}
+	if ! slices.Equal(args, want) {
+		t.Error("Own rules", args, "want", want)
+	}
+	for _, arg := range args {
+		if ! strings.Contains(arg, ephemeralSuffix) {
+			t.Error("Own rule reaches names of the default instance: " + arg)
+		}
+	}
+}
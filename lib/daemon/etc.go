@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Entries of the host /etc visible in minimal mode. localtime is bound by localtimeBind,
+// passwd, group, gshadow and nsswitch.conf are generated
+var etcAllowDefault = []string{
+	"ld.so.cache",
+	"ssl/certs",
+	"fonts",
+	"resolv.conf",
+	"hosts",
+	"mime.types",
+	"alternatives",
+	"pki",
+	"os-release",
+}
+
+// Files the sandbox layout binds anyway, /etc links pointing at them need no extra bind
+var etcLinkTargetsBound = []string{
+	"/run/systemd/resolve/stub-resolv.conf",
+}
+
+// Entries provided by Portable itself, which the host may not replace
+var etcGenerated = []string{
+	"passwd",
+	"group",
+	"gshadow",
+	"nsswitch.conf",
+	"localtime",
+}
+
+func etcMinimal(config Config) bool {
+	switch config.Privacy.Etc {
+		case "minimal":
+			return true
+		case "full":
+		default:
+			pecho("warn", "Unknown /etc mode " + config.Privacy.Etc + ", using full")
+	}
+	return false
+}
+
+// Binds an entry of the host /etc. Symbolic links are recreated, with their
+// target bound at the same path unless it is already part of the sandbox,
+// either below /usr or in etcLinkTargetsBound
+func etcEntryBinds(name string) []Mount {
+	path := filepath.Join("/etc", name)
+	info, err := os.Lstat(path)
+	if err != nil {
+		pecho("debug", "Skipping " + path + ":", err)
+		return []Mount{}
+	}
+	if info.Mode() & os.ModeSymlink == 0 {
+		return []Mount{bindMount(mountRoBind, path, path)}
+	}
+	link, err := os.Readlink(path)
+	if err != nil {
+		pecho("warn", "Could not read link " + path + ":", err)
+		return []Mount{}
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		pecho("debug", "Skipping dangling link " + path + ":", err)
+		return []Mount{}
+	}
+	mounts := []Mount{symlinkMount(link, path)}
+	if ! pathWithin(target, "/usr") && ! slices.Contains(etcLinkTargetsBound, target) {
+		mounts = append(mounts, bindMount(mountRoBind, target, target))
+	}
+	return mounts
+}
+
+// Builds /etc of the sandbox, either the host directory or the allowlist
+func etcBinds(config Config) []Mount {
+	if ! etcMinimal(config) {
+		return []Mount{bindMount(mountRoBind, "/etc", "/etc")}
+	}
+	mounts := []Mount{{Kind: mountDir, Dest: "/etc", Perms: "0755"}}
+	for _, entry := range slices.Concat(etcAllowDefault, config.Privacy.EtcAllow) {
+		name := filepath.Clean(strings.TrimPrefix(entry, "/etc/"))
+		if filepath.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			pecho("warn", "Ignoring /etc entry outside of /etc: " + entry)
+			continue
+		}
+		if slices.Contains(etcGenerated, name) || name == "machine-id" || config.Privacy.AnonymizeIdentity && name == "hostname" {
+			pecho("warn", "Ignoring /etc entry provided by Portable: " + entry)
+			continue
+		}
+		mounts = append(mounts, etcEntryBinds(name)...)
+	}
+	return mounts
+}
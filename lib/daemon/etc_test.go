@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestEtcBinds(t *testing.T) {
+	var config Config
+	config.Privacy.Etc = "full"
+	if mounts := etcBinds(config); len(mounts) != 1 || mounts[0].Src != "/etc" {
+		t.Error("Full mode should bind the host /etc")
+	}
+
+	config.Privacy.Etc = "minimal"
+	config.Privacy.EtcAllow = []string{"../root", "/etc/../home", "passwd", "/etc/hosts", "machine-id"}
+	mounts := etcBinds(config)
+	if mounts[0].Kind != mountDir || mounts[0].Dest != "/etc" {
+		t.Error("Minimal mode should start from an empty /etc")
+	}
+	var dests []string
+	for _, m := range mounts {
+		if m.Src == "/etc" || m.Src == "/etc/passwd" || m.Src == "/etc/machine-id" || pathWithin(m.Dest, "/root") || pathWithin(m.Dest, "/home") {
+			t.Error("Unexpected mount " + m.String())
+		}
+		dests = append(dests, m.Dest)
+	}
+	if _, err := os.Stat("/etc/hosts"); err == nil && ! slices.Contains(dests, "/etc/hosts") {
+		t.Error("/etc/hosts should be visible")
+	}
+}
+
+func TestMinimalMachineID(t *testing.T) {
+	var config Config
+	config.Metadata.AppID = "org.example.App"
+	config.Privacy.Etc = "full"
+	if mounts := identityBinds(config); len(mounts) > 0 {
+		t.Error("Full mode without anonymizeIdentity replaced identity files:", mounts)
+	}
+
+	config.Privacy.Etc = "minimal"
+	var dests []string
+	for _, m := range identityBinds(config) {
+		dests = append(dests, m.Dest)
+	}
+	want := []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+	if ! slices.Equal(dests, want) {
+		t.Error("Minimal mode binds", dests, "want", want)
+	}
+}
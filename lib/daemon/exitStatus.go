@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Exit code used when the application is killed by the OOM killer. Above 128 + SIGRTMAX,
+// so it can not be mistaken for a signal, the OOM killer itself sends SIGKILL
+const oomExitCode = 250
+
+// siginfo codes reported via ExecMainCode
+const (
+	cldExited	= 1
+	cldKilled	= 2
+	cldDumped	= 3
+)
+
+type appExit struct {
+	Code		int
+	// Result of the application unit, e.g. success, exit-code, signal or oom-kill
+	Result		string
+}
+
+func parseInt32(v any) (int32, bool) {
+	switch n := v.(type) {
+		case int32:
+			return n, true
+		default:
+			return 0, false
+	}
+}
+
+// Maps the main process status of the application unit to an exit code. Signals are
+// reported as 128 + N even if systemd considers them clean, e.g. SIGTERM
+func mapAppExit(result string, mainCode int32, mainStatus int32) appExit {
+	if result == "oom-kill" {
+		return appExit{Code: oomExitCode, Result: result}
+	}
+	switch mainCode {
+		case cldKilled, cldDumped:
+			return appExit{Code: 128 + int(mainStatus), Result: result}
+		case cldExited:
+			if mainStatus > 0 || result == "success" {
+				return appExit{Code: int(mainStatus), Result: result}
+			}
+	}
+	if result == "success" {
+		return appExit{Code: 0, Result: result}
+	}
+	return appExit{Code: 1, Result: result}
+}
+
+// Reads the result of the application unit, falling back to the exit code of systemd-run
+func appExitStatus(conn *dbus.Conn, config Config, runErr error) appExit {
+	var fallback = appExit{Code: 0, Result: "success"}
+	var exitErr *exec.ExitError
+	if runErr != nil {
+		fallback = appExit{Code: 1, Result: "exit-code"}
+		if errors.As(runErr, &exitErr) && exitErr.ExitCode() > 0 {
+			fallback.Code = exitErr.ExitCode()
+		}
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 1 * time.Second)
+	defer cancelFunc()
+	props, err := conn.GetUnitTypePropertiesContext(ctx, appUnitName(config), "Service")
+	if err != nil {
+		pecho("debug", "Could not query application result:", err)
+		return fallback
+	}
+	result := parseStr(props["Result"])
+	mainCode, okCode := parseInt32(props["ExecMainCode"])
+	mainStatus, okStatus := parseInt32(props["ExecMainStatus"])
+	if len(result) == 0 || ! okCode || ! okStatus {
+		return fallback
+	}
+	// Stopping escalates to SIGKILL, which is expected and leaves the unit failed
+	if stopRequested.Load() {
+		if result != "success" {
+			err := conn.ResetFailedUnitContext(ctx, appUnitName(config))
+			if err != nil {
+				pecho("debug", "Could not reset failed state of application unit:", err)
+			}
+		}
+		return appExit{Code: 0, Result: result}
+	}
+	return mapAppExit(result, mainCode, mainStatus)
+}
+
+// Emits the Exited signal on the session bus
+func emitExited(config Config, status appExit) {
+	conn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("warn", "Could not connect to session bus:", err)
+		return
+	}
+	err = conn.Emit(
+		"/top/kimiblock/portable/daemon",
+		"top.kimiblock.Portable.Controller.Exited",
+		int32(status.Code),
+		status.Result,
+	)
+	if err != nil {
+		pecho("warn", "Could not emit Exited signal:", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestMapAppExit(t *testing.T) {
+	for _, tc := range []struct {
+		result		string
+		code		int32
+		status		int32
+		want		int
+	}{
+		{"success", cldExited, 0, 0},
+		{"exit-code", cldExited, 3, 3},
+		{"signal", cldKilled, 9, 137},
+		{"signal", cldDumped, 11, 139},
+		// Clean signals are successful for systemd, but not for wrapper scripts
+		{"success", cldKilled, 15, 143},
+		{"oom-kill", cldKilled, 9, oomExitCode},
+		{"timeout", 0, 0, 1},
+	} {
+		if got := mapAppExit(tc.result, tc.code, tc.status); got.Code != tc.want || got.Result != tc.result {
+			t.Error("Result", tc.result, "code", tc.code, "status", tc.status, "mapped to", got.Code, "want", tc.want)
+		}
+	}
+}
+
+func TestOOMExitCode(t *testing.T) {
+	oom := mapAppExit("oom-kill", cldKilled, 9)
+	killed := mapAppExit("signal", cldKilled, 9)
+	if oom.Code == killed.Code {
+		t.Error("OOM kill and SIGKILL both map to", oom.Code)
+	}
+	// Real-time signals go up to 64
+	for sig := int32(1); sig <= 64; sig++ {
+		if mapAppExit("signal", cldKilled, sig).Code == oomExitCode {
+			t.Error("OOM exit code collides with signal", sig)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,16 +12,19 @@ import (
 	godbus "github.com/godbus/dbus/v5"
 )
 
-func engageExpose(chann chan map[string]string, conf Config, docsChan chan PassFiles) []string {
+// Mounts of exposed paths, marked as user mounts so mountBuilder checks their destinations
+func engageExpose(chann chan map[string]string, conf Config, docsChan chan PassFiles) []Mount {
 	close(chann)
-	var bwArgs []string
-	var bwArgChan = make(chan []string, 512)
+	var mounts []Mount
+	var mountChan = make(chan Mount, 512)
 	var portalFiles = make(chan string, 512)
 	var wg sync.WaitGroup
 	var writeWg sync.WaitGroup
 
 	var pathsChan = make(chan string, 512)
 	var consentChan = make(chan bool, 5)
+	// Requested paths and their destinations, for the audit log
+	var requested sync.Map
 	defer close(consentChan)
 	go func () {
 		var paths []string
@@ -36,8 +41,8 @@ func engageExpose(chann chan map[string]string, conf Config, docsChan chan PassF
 
 
 	writeWg.Go(func() {
-		for sig := range bwArgChan {
-			bwArgs = append(bwArgs, sig...)
+		for mnt := range mountChan {
+			mounts = append(mounts, mnt)
 		}
 	})
 	writeWg.Go(func() {
@@ -75,24 +80,28 @@ func engageExpose(chann chan map[string]string, conf Config, docsChan chan PassF
 					return
 				}
 				pathsChan <- ori
+				requested.Store(ori, dest)
 				if strings.HasPrefix(dest, "ro:") {
-					bwArgChan <- []string{
-						"--ro-bind",
-						ori,
-						strings.TrimPrefix(dest, "ro:"),
+					mountChan <- Mount{
+						Kind:	mountRoBind,
+						Src:	ori,
+						Dest:	strings.TrimPrefix(dest, "ro:"),
+						User:	true,
 					}
 				} else if strings.HasPrefix(dest, "dev:") {
-					bwArgChan <- []string{
-						"--dev-bind",
-						ori,
-						strings.TrimPrefix(dest, "dev:"),
+					mountChan <- Mount{
+						Kind:	mountDevBind,
+						Src:	ori,
+						Dest:	strings.TrimPrefix(dest, "dev:"),
+						User:	true,
 					}
 				} else if dest == "null" {
 				} else {
-					bwArgChan <- []string{
-						"--bind",
-						ori,
-						dest,
+					mountChan <- Mount{
+						Kind:	mountBind,
+						Src:	ori,
+						Dest:	dest,
+						User:	true,
 					}
 				}
 				if filepath.IsAbs(ori) && ! stat.IsDir() {
@@ -109,14 +118,27 @@ func engageExpose(chann chan map[string]string, conf Config, docsChan chan PassF
 
 	}
 	wg.Wait()
-	close(bwArgChan)
+	close(mountChan)
 	close(portalFiles)
 	close(pathsChan)
 	writeWg.Wait()
-	if consent := <- consentChan; consent {
-		return bwArgs
+	consent := <- consentChan
+	requested.Range(func(key, value any) bool {
+		event := "expose"
+		if value.(string) == "null" {
+			event = "forward"
+		}
+		decision := auditDenied
+		if consent {
+			decision = auditGranted
+		}
+		audit(conf, event, decision, key.(string), value.(string))
+		return true
+	})
+	if consent {
+		return mounts
 	} else {
-		return []string{}
+		return []Mount{}
 	}
 
 }
@@ -172,6 +194,8 @@ func addFilesToPortal(connBus *godbus.Conn, pathList []string, filesInfo chan Pa
 		pecho("warn", "Could not decode portal response: " + err.Error())
 	}
 	for idx, docid := range resp.DocIDs {
+		revokeDocumentOnStop(connBus, docid, config.Metadata.AppID)
+		audit(config, "document", auditGranted, pathList[idx], "document " + docid + ", " + strings.Join(busData.Permissions, ","))
 		filesInfoTmp.FileMap[pathList[idx]] = filepath.Join(
 			xdgDir.runtimeDir,
 			"/doc/",
@@ -182,4 +206,178 @@ func addFilesToPortal(connBus *godbus.Conn, pathList []string, filesInfo chan Pa
 	jsonObj, _ := json.Marshal(filesInfoTmp)
 	addEnv("_portableHelperExtraFiles=" + string(jsonObj))
 	filesInfo <- filesInfoTmp
-}
\ No newline at end of file
+}
+// Revokes permissions granted during this session once the sandbox stops
+func revokeDocumentOnStop(connBus *godbus.Conn, docID string, appID string) {
+	addStopHook(stageDocuments, func() {
+		obj := connBus.Object("org.freedesktop.portal.Documents", "/org/freedesktop/portal/documents")
+		call := obj.Call("org.freedesktop.portal.Documents.RevokePermissions", 0,
+			docID,
+			appID,
+			[]string{"read", "write", "grant-permissions", "delete"},
+		)
+		if call.Err != nil {
+			pecho("warn", "Could not revoke document " + docID + ": " + call.Err.Error())
+		}
+	})
+}
+
+const (
+	// Exposes the path read-only
+	exposeFlagReadOnly	uint32	=	1 << 0
+)
+
+// Grants the sandbox access to a single path through the Documents portal, returns the document ID
+func addPathToPortal(connBus *godbus.Conn, path string, directory bool, readOnly bool, appID string) (string, error) {
+	fileObj, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fileObj.Close()
+	// reuse_existing, plus export_directory for directories
+	var flags uint32 = 1
+	if directory {
+		flags = flags | 8
+	}
+	perms := []string{"read", "write", "grant-permissions"}
+	if readOnly {
+		perms = []string{"read"}
+	}
+	obj := connBus.Object("org.freedesktop.portal.Documents", "/org/freedesktop/portal/documents")
+	call := obj.Call("org.freedesktop.portal.Documents.AddFull", 0,
+		[]godbus.UnixFD{godbus.UnixFD(fileObj.Fd())},
+		flags,
+		appID,
+		perms,
+	)
+	if call.Err != nil {
+		return "", call.Err
+	}
+	var docIDs []string
+	var extraInfo map[string]godbus.Variant
+	err = call.Store(&docIDs, &extraInfo)
+	if err != nil {
+		return "", err
+	}
+	if len(docIDs) != 1 {
+		return "", errors.New("Documents portal returned no document ID")
+	}
+	return docIDs[0], nil
+}
+
+// Exposes a host path into a running sandbox, returns the path visible inside the sandbox
+func (m *DBusControlRequest) Expose(host string, dest string, flags uint32) (string, *godbus.Error) {
+	if ! filepath.IsAbs(host) {
+		return "", godbus.MakeFailedError(errors.New("Host path must be absolute"))
+	}
+	stat, err := os.Stat(host)
+	if err != nil {
+		return "", godbus.MakeFailedError(err)
+	}
+	if strings.HasPrefix(dest, "dev:") {
+		return "", godbus.MakeFailedError(errors.New("Device nodes can not be exposed into a running sandbox"))
+	}
+	if ! questionExpose([]string{host}, m.Config) {
+		audit(m.Config, "expose", auditDenied, host, dest)
+		return "", godbus.MakeFailedError(errors.New("User denied exposing " + host))
+	}
+	audit(m.Config, "expose", auditGranted, host, dest)
+	docID, err := addPathToPortal(
+		m.Conn,
+		host,
+		stat.IsDir(),
+		flags & exposeFlagReadOnly != 0,
+		m.Config.Metadata.AppID,
+	)
+	if err != nil {
+		pecho("warn", "Could not expose path via Documents portal:", err)
+		return "", godbus.MakeFailedError(err)
+	}
+	revokeDocumentOnStop(m.Conn, docID, m.Config.Metadata.AppID)
+	var permissions = "read,write,grant-permissions"
+	if flags & exposeFlagReadOnly != 0 {
+		permissions = "read"
+	}
+	audit(m.Config, "document", auditGranted, host, "document " + docID + ", " + permissions)
+	sandboxPath := filepath.Join(xdgDir.runtimeDir, "doc", docID, filepath.Base(host))
+	pecho("info", "Exposed " + host + " at " + sandboxPath)
+
+	if len(dest) == 0 || dest == "null" {
+		return sandboxPath, nil
+	}
+	linkPath, err := exposeLinkPath(dest, m.Config)
+	if err != nil {
+		pecho("warn", "Could not honour destination " + dest + " for a running sandbox:", err)
+		return sandboxPath, nil
+	}
+	err = os.MkdirAll(filepath.Dir(linkPath), 0700)
+	if err == nil {
+		err = os.Symlink(sandboxPath, linkPath)
+	}
+	if err != nil {
+		pecho("warn", "Could not link exposed path to destination:", err)
+		return sandboxPath, nil
+	}
+	return filepath.Clean(dest), nil
+}
+
+// Maps a destination inside the sandbox's home to the host path of its link. Mount namespace
+// of a running sandbox is sealed, so exposed paths are linked from the state directory instead
+func exposeLinkPath(dest string, config Config) (string, error) {
+	dest = filepath.Clean(dest)
+	statePath := filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory)
+	var linkPath string
+	if strings.HasPrefix(dest, statePath + "/") {
+		linkPath = dest
+	} else if strings.HasPrefix(dest, xdgDir.home + "/") {
+		linkPath = translatePath(dest, config)
+	} else {
+		return "", errors.New("it must be inside the home directory")
+	}
+	// The host directory backing the home differs for ephemeral instances
+	source := stateSource(config)
+	linkPath = filepath.Join(source, strings.TrimPrefix(linkPath, statePath))
+	if ! strings.HasPrefix(linkPath, source + "/") {
+		return "", errors.New("it resolves outside of the state directory")
+	}
+	return linkPath, nil
+}
+
+// Routes --expose requests to an already running instance
+func exposeLive(config Config, exposeMap map[string]string) {
+	if len(exposeMap) == 0 {
+		return
+	}
+	conn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("crit", "Could not connect to session bus:", err)
+		return
+	}
+	busName := "top.kimiblock.portable." + config.Metadata.AppID
+	busObj := conn.Object(busName, "/top/kimiblock/portable/daemon")
+	for host, dest := range exposeMap {
+		var flags uint32
+		if after, ok := strings.CutPrefix(dest, "ro:"); ok {
+			flags = flags | exposeFlagReadOnly
+			dest = after
+		}
+		call := busObj.Call(
+			"top.kimiblock.Portable.Controller.Expose",
+			godbus.FlagAllowInteractiveAuthorization,
+			host,
+			dest,
+			flags,
+		)
+		if call.Err != nil {
+			pecho("warn", "Could not expose " + host + " into running instance: " + call.Err.Error())
+			continue
+		}
+		var sandboxPath string
+		err := call.Store(&sandboxPath)
+		if err != nil {
+			pecho("warn", "Could not decode bus reply:", err)
+			continue
+		}
+		fmt.Println("Exposed " + host + " at " + sandboxPath)
+	}
+}
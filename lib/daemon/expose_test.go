@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestExposeLinkPath(t *testing.T) {
+	oldHome, oldDataDir := xdgDir.home, xdgDir.dataDir
+	xdgDir.home = "/home/test"
+	xdgDir.dataDir = "/home/test/.local/share"
+	defer func () {
+		xdgDir.home, xdgDir.dataDir = oldHome, oldDataDir
+	} ()
+
+	var config Config
+	config.Metadata.StateDirectory = "App"
+	for dest, want := range map[string]string{
+		"/home/test/Documents/report":			"/home/test/.local/share/App/Documents/report",
+		"/home/test/.local/share/App/x":		"/home/test/.local/share/App/x",
+		"/home/test/a/../b":				"/home/test/.local/share/App/b",
+		"/home/test/../../etc/cron.d/x":		"",
+		"/home/test/.local/share/App/../../x":		"/home/test/.local/share/App/.local/x",
+		"/home/test":					"",
+		"/tmp/x":					"",
+	} {
+		got, err := exposeLinkPath(dest, config)
+		if len(want) == 0 && err == nil {
+			t.Error("Accepted destination " + dest + " as " + got)
+		}
+		if len(want) > 0 && got != want {
+			t.Error("Destination " + dest + " mapped to " + got + ", want " + want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Marks instance directories created by Portable, see /usr/lib/portable/flatpak-info
+const portableRuntimeRef = "runtime/org.kraftland.host"
+
+// Reads the application ID of an instance directory, only succeeds for instances created by Portable
+func instanceAppID(id string) (string, bool) {
+	file, err := os.Open(filepath.Join(xdgDir.runtimeDir, ".flatpak", id, "info"))
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+	var appID string
+	var ours bool
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, val, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ! ok {
+			continue
+		}
+		switch key {
+			case "name":
+				if len(appID) == 0 {
+					appID = val
+				}
+			case "runtime":
+				ours = strings.HasPrefix(val, portableRuntimeRef + "/")
+		}
+	}
+	return appID, ours && len(appID) > 0
+}
+
+// Whether the daemon of appID is running in another process
+func daemonRunning(busConn *godbus.Conn, appID string) bool {
+	if busConn == nil {
+		return true
+	}
+	var owner string
+	err := busConn.BusObject().Call(
+		"org.freedesktop.DBus.GetNameOwner",
+		godbus.FlagNoAutoStart,
+		"top.kimiblock.portable." + appID,
+	).Store(&owner)
+	if err != nil {
+		return false
+	}
+	names := busConn.Names()
+	return len(names) == 0 || owner != names[0]
+}
+
+func unitStatusActive(unit dbus.UnitStatus) bool {
+	switch unit.ActiveState {
+		case "inactive", "failed":
+			return false
+		default:
+			return true
+	}
+}
+
+// Lists loaded units matching patterns, the error is non-nil when the user manager could not be asked
+func listUnits(ctx context.Context, conn *dbus.Conn, patterns ...string) ([]dbus.UnitStatus, error) {
+	if conn == nil {
+		return nil, os.ErrInvalid
+	}
+	return conn.ListUnitsByPatternsContext(ctx, nil, patterns)
+}
+
+// Whether an instance is left behind by a daemon that no longer runs.
+// Anything that can not be verified is treated as live
+func instanceStale(ctx context.Context, conn *dbus.Conn, busConn *godbus.Conn, id string) bool {
+	appID, ok := instanceAppID(id)
+	if ! ok {
+		return false
+	}
+	if daemonRunning(busConn, appID) {
+		return false
+	}
+	units, err := listUnits(ctx, conn, "app-portable-*-" + id + ".service")
+	if err != nil {
+		pecho("debug", "Could not list units of instance " + id + ":", err)
+		return false
+	}
+	for _, unit := range units {
+		if unitStatusActive(unit) {
+			return false
+		}
+	}
+	return true
+}
+
+// Stops the leftover D-Bus proxy and removes runtime directories of an instance
+func removeInstance(ctx context.Context, conn *dbus.Conn, id string) {
+	units, err := listUnits(ctx, conn, "*-" + id + "-dbus.service", "app-portable-*-" + id + ".service")
+	if err != nil {
+		pecho("debug", "Could not list units of instance " + id + ":", err)
+	}
+	for _, unit := range units {
+		if unitStatusActive(unit) {
+			_, err := conn.StopUnitContext(ctx, unit.Name, "replace", nil)
+			if err != nil {
+				pecho("warn", "Could not stop " + unit.Name + ":", err)
+			}
+		} else if unit.ActiveState == "failed" {
+			err := conn.ResetFailedUnitContext(ctx, unit.Name)
+			if err != nil {
+				pecho("debug", "Could not reset " + unit.Name + ":", err)
+			}
+		}
+	}
+	for _, dir := range []string{id, id + "-private"} {
+		err := os.RemoveAll(filepath.Join(xdgDir.runtimeDir, ".flatpak", dir))
+		if err != nil {
+			pecho("warn", "Could not remove instance directory:", err)
+		}
+	}
+}
+
+// Removes an occupied instance ID if it is stale, so it can be used again
+func reclaimInstance(conn *dbus.Conn, busConn *godbus.Conn, id string) bool {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancelFunc()
+	if ! instanceStale(ctx, conn, busConn, id) {
+		return false
+	}
+	pecho("debug", "Reclaiming stale instance ID " + id)
+	removeInstance(ctx, conn, id)
+	return true
+}
+
+// Removes stale instances of all applications, except the given instance ID
+func gcInstances(conn *dbus.Conn, busConn *godbus.Conn, exclude string) int {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancelFunc()
+	entries, err := os.ReadDir(filepath.Join(xdgDir.runtimeDir, ".flatpak"))
+	if err != nil {
+		pecho("debug", "Could not list instances:", err)
+		return 0
+	}
+	var removed int
+	for _, entry := range entries {
+		id := entry.Name()
+		if ! entry.IsDir() || id == exclude {
+			continue
+		}
+		if _, err := strconv.Atoi(id); err != nil {
+			continue
+		}
+		if ! instanceStale(ctx, conn, busConn, id) {
+			continue
+		}
+		pecho("debug", "Removing stale instance " + id)
+		removeInstance(ctx, conn, id)
+		removed++
+	}
+	return removed
+}
+
+// Removes per-application runtime state when the application is not running
+func gcAppState(conn *dbus.Conn, busConn *godbus.Conn, config Config) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancelFunc()
+	appID := config.Metadata.AppID
+	if daemonRunning(busConn, appID) {
+		pecho("info", "Skipping state of " + appID + ": application is running")
+		return
+	}
+	units, err := listUnits(ctx, conn, "app-portable-" + appID + "-*.service")
+	if err != nil {
+		pecho("warn", "Could not list units of " + appID + ":", err)
+		return
+	}
+	for _, unit := range units {
+		if unitStatusActive(unit) {
+			pecho("info", "Skipping state of " + appID + ": " + unit.Name + " is active")
+			return
+		}
+	}
+	for _, dir := range []string{
+		filepath.Join(xdgDir.runtimeDir, ".flatpak", appID),
+		filepath.Join(xdgDir.runtimeDir, "app", appID),
+		filepath.Join(xdgDir.runtimeDir, "portable", appID, "a11y"),
+		filepath.Join(xdgDir.runtimeDir, "portable", appID + ".control"),
+	} {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			pecho("warn", "Could not remove directory:", err)
+		}
+	}
+	stubPath := filepath.Join(xdgDir.dataDir, "applications", appID + ".desktop")
+	content, err := os.ReadFile(stubPath)
+	if err == nil && string(content) == stubDesktopEntry(config) {
+		err := os.Remove(stubPath)
+		if err != nil {
+			pecho("warn", "Could not remove stub .desktop file:", err)
+		}
+	}
+}
+
+// Handles --actions gc
+func collectGarbage(config Config) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancelFunc()
+	conn, err := dbus.NewUserConnectionContext(ctx)
+	if err != nil {
+		pecho("warn", "Could not connect to user service manager:", err)
+		return
+	}
+	defer conn.Close()
+	busConn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("warn", "Could not connect to session bus:", err)
+		return
+	}
+	removed := gcInstances(conn, busConn, runtimeInfo.instanceID)
+	gcAppState(conn, busConn, config)
+	pecho("info", "Removed " + strconv.Itoa(removed) + " stale instance(s)")
+}
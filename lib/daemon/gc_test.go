@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstanceAppID(t *testing.T) {
+	oldRuntimeDir := xdgDir.runtimeDir
+	xdgDir.runtimeDir = t.TempDir()
+	defer func () {
+		xdgDir.runtimeDir = oldRuntimeDir
+	} ()
+
+	var infos = map[string]string{
+		"1":	"[Application]\nname=org.example.Portable\nruntime=runtime/org.kraftland.host/x86_64/1\n",
+		"2":	"[Application]\nname=org.example.Flatpak\nruntime=runtime/org.gnome.Platform/x86_64/48\n",
+	}
+	for id, info := range infos {
+		err := os.MkdirAll(filepath.Join(xdgDir.runtimeDir, ".flatpak", id), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(xdgDir.runtimeDir, ".flatpak", id, "info"), []byte(info), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if appID, ok := instanceAppID("1"); ! ok || appID != "org.example.Portable" {
+		t.Fatal("Expected Portable instance, got:", appID, ok)
+	}
+	if _, ok := instanceAppID("2"); ok {
+		t.Fatal("Flatpak instance must not be treated as ours")
+	}
+	if _, ok := instanceAppID("3"); ok {
+		t.Fatal("Missing instance must not be treated as ours")
+	}
+}
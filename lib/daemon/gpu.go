@@ -37,7 +37,7 @@ func detectCardBrand(dev *udev.Device) (gpuBrand, error) {
 	}
 }
 
-func gpuBind(gpuChan chan []string, config Config) {
+func gpuBind(gpuChan chan []Mount, config Config) {
 	var gameModeEnabledChan = make(chan bool, 1)
 	var chanWg sync.WaitGroup
 	var wg sync.WaitGroup
@@ -63,8 +63,8 @@ func gpuBind(gpuChan chan []string, config Config) {
 		}
 	} ()
 
-	var argChan = make(chan []string, 128)
-	var gpuArg = []string{"--tmpfs", "/dev/dri", "--tmpfs", "/sys/class/drm"}
+	var argChan = make(chan []Mount, 128)
+	var gpuArg = []Mount{tmpfsMount("/dev/dri"), tmpfsMount("/sys/class/drm")}
 	chanWg.Go(func() {
 		for arg := range argChan {
 			gpuArg = append(gpuArg, arg...)
@@ -109,7 +109,13 @@ func gpuBind(gpuChan chan []string, config Config) {
 	}
 
 
+	// NVIDIA cards bind the module directories instead, see bindCard
 	wg.Go(func() {
+		for _, card := range cardsToBind {
+			if brand, _ := detectCardBrand(card); brand == "nvidia" {
+				return
+			}
+		}
 		var workers sync.WaitGroup
 		defer workers.Wait()
 		for _, path := range nvKernelModulePath {
@@ -151,11 +157,11 @@ func setOffloadEnvs(hasNvidia bool) {
 	}
 }
 
-func bindCard(cardDevice *udev.Device, argChanFin chan []string, config Config) {
+func bindCard(cardDevice *udev.Device, argChanFin chan []Mount, config Config) {
 	var sendWg sync.WaitGroup
-	var argComb = make(chan []string, 5)
+	var argComb = make(chan []Mount, 5)
 	sendWg.Go(func() {
-		var args []string
+		var args []Mount
 		for arg := range argComb {
 			args = append(args, arg...)
 		}
@@ -184,10 +190,8 @@ func bindCard(cardDevice *udev.Device, argChanFin chan []string, config Config)
 				return
 			}
 			pecho("debug", "Binding parent device for GPU:", parent.Syspath())
-			argComb <- []string{
-				"--dev-bind",
-					parent.Syspath(),
-					parent.Syspath(),
+			argComb <- []Mount{
+				bindMount(mountDevBind, parent.Syspath(), parent.Syspath()),
 			}
 		}
 	})
@@ -206,10 +210,8 @@ func bindCard(cardDevice *udev.Device, argChanFin chan []string, config Config)
 		switch vendor {
 			case "amd":
 				if _, err := os.Stat("/dev/kfd"); err == nil {
-					argComb <- []string{
-						"--dev-bind",
-						"/dev/kfd",
-						"/dev/kfd",
+					argComb <- []Mount{
+						bindMount(mountDevBind, "/dev/kfd", "/dev/kfd"),
 					}
 				}
 			case "nvidia":
@@ -234,9 +236,8 @@ func bindCard(cardDevice *udev.Device, argChanFin chan []string, config Config)
 					wg.Go(func() {
 						stat, err := os.Stat(path)
 						if err == nil && stat.IsDir() {
-							argComb <- []string{
-								"--ro-bind",
-								path, path,
+							argComb <- []Mount{
+								bindMount(mountRoBind, path, path),
 							}
 						} else {
 							pecho("debug", "Skipping non-existent path:", path)
@@ -252,23 +253,15 @@ func bindCard(cardDevice *udev.Device, argChanFin chan []string, config Config)
 	//nodeName := filepath.Base(devNode)
 	sysPath := cardDevice.Syspath()
 	for k := range cardDevice.Devlinks() {
-		argComb <- []string{
-			"--symlink",
-				sysPath,
-				k,
+		argComb <- []Mount{
+			symlinkMount(sysPath, k),
 		}
 	}
 	//cardRoot := strings.TrimSuffix(sysPath, "/drm/" + cardName)
-	argComb <- []string{
-		"--symlink",
-			sysPath,
-			"/sys/class/drm/" + cardDevice.Sysname(),
-		"--dev-bind",
-			devNode,
-			devNode,
-		"--dev-bind",
-			sysPath,
-			sysPath,
+	argComb <- []Mount{
+		symlinkMount(sysPath, "/sys/class/drm/" + cardDevice.Sysname()),
+		bindMount(mountDevBind, devNode, devNode),
+		bindMount(mountDevBind, sysPath, sysPath),
 	}
 	cardID := cardDevice.PropertyValue("ID_PATH")
 	u := udev.Udev{}
@@ -303,19 +296,13 @@ func bindCard(cardDevice *udev.Device, argChanFin chan []string, config Config)
 	sysPath = rendererSlice[0].Syspath()
 
 	for k := range rendererSlice[0].Devlinks() {
-		argComb <- []string{
-			"--symlink",
-				sysPath,
-				k,
+		argComb <- []Mount{
+			symlinkMount(sysPath, k),
 		}
 	}
 
-	argComb <- []string{
-		"--dev-bind",
-			renderDevPath,
-			renderDevPath,
-		"--symlink",
-			sysPath,
-			"/sys/class/drm/" + rendererSlice[0].Sysname(),
+	argComb <- []Mount{
+		bindMount(mountDevBind, renderDevPath, renderDevPath),
+		symlinkMount(sysPath, "/sys/class/drm/" + rendererSlice[0].Sysname()),
 	}
 }
\ No newline at end of file
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Formats group and gshadow entries. Supplementary groups list the user as member
+func formatGroups(username string, primary user.Group, supplementary []user.Group) (string, string) {
+	var group strings.Builder
+	var gshadow strings.Builder
+	write := func(name string, gid string, members string) {
+		group.WriteString(name + ":x:" + gid + ":" + members + "\n")
+		gshadow.WriteString(name + ":!::" + members + "\n")
+	}
+	write(primary.Name, primary.Gid, "")
+	for _, grp := range supplementary {
+		if grp.Gid == primary.Gid || grp.Gid == "65534" {
+			continue
+		}
+		write(grp.Name, grp.Gid, username)
+	}
+	// Overflow group
+	if primary.Gid != "65534" {
+		write("nobody", "65534", "")
+	}
+	return group.String(), gshadow.String()
+}
+
+// Looks up groups of system.supplementaryGroups the user is a member of
+func supplementaryGroups(config Config, current *user.User) []user.Group {
+	var res []user.Group
+	if len(config.System.SupplementaryGroups) == 0 {
+		return res
+	}
+	gids, err := current.GroupIds()
+	if err != nil {
+		pecho("warn", "Could not list groups of user:", err)
+		return res
+	}
+	for _, name := range config.System.SupplementaryGroups {
+		grp, err := user.LookupGroup(name)
+		if err != nil {
+			pecho("warn", "Could not look up group " + name + ":", err)
+			continue
+		}
+		if ! slices.Contains(gids, grp.Gid) {
+			pecho("warn", "User is not a member of group " + name + ", skipping")
+			continue
+		}
+		res = append(res, *grp)
+	}
+	return res
+}
+
+func generateGroupFile(config Config) {
+	current, err := user.Current()
+	if err != nil {
+		pecho("warn", "Could not get current user info")
+		return
+	}
+	primary := user.Group{Gid: current.Gid, Name: current.Username}
+	if grp, err := user.LookupGroupId(current.Gid); err == nil {
+		primary = *grp
+	} else {
+		pecho("debug", "Could not look up primary group:", err)
+	}
+	group, gshadow := formatGroups(current.Username, primary, supplementaryGroups(config, current))
+	dir := filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID)
+	err = os.WriteFile(filepath.Join(dir, "group"), []byte(group), 0700)
+	if err != nil {
+		pecho("warn", "Could not write fake group file:", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "gshadow"), []byte(gshadow), 0600)
+	if err != nil {
+		pecho("warn", "Could not write fake gshadow file:", err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os/user"
+	"testing"
+)
+
+func TestFormatGroups(t *testing.T) {
+	group, gshadow := formatGroups(
+		"alice",
+		user.Group{Gid: "1000", Name: "alice"},
+		[]user.Group{
+			{Gid: "39", Name: "video"},
+			{Gid: "1000", Name: "alice"},
+		},
+	)
+	if group != "alice:x:1000:\nvideo:x:39:alice\nnobody:x:65534:\n" {
+		t.Error("Unexpected group file: " + group)
+	}
+	if gshadow != "alice:!::\nvideo:!::alice\nnobody:!::\n" {
+		t.Error("Unexpected gshadow file: " + gshadow)
+	}
+}
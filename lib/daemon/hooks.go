@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Set once preStart hooks succeeded, postStop hooks only run after that
+var preStartDone atomic.Bool
+
+// Whether hooks of the configuration may run, they execute unsandboxed and
+// are thus only accepted from system or user configuration directories
+func hooksAllowed(config Config) bool {
+	if ! config.isModern {
+		return false
+	}
+	path, err := filepath.EvalSymlinks(config.Path)
+	if err != nil {
+		pecho("warn", "Could not resolve configuration path:", err)
+		return false
+	}
+	for _, dir := range []string{
+		"/usr/lib/portable/info",
+		filepath.Join(xdgDir.confDir, "portable", "info"),
+	} {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		if strings.HasPrefix(path, dir + "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// exitStatus is only exported if set, i.e. for postStop
+func hookEnv(config Config, exitStatus string) []string {
+	env := append(
+		os.Environ(),
+		"APPID=" + config.Metadata.AppID,
+		"INSTANCE_ID=" + runtimeInfo.instanceID,
+		"STATE_DIR=" + stateSource(config),
+		"UNIT=" + appUnitName(config),
+	)
+	if len(exitStatus) > 0 {
+		env = append(env, "EXIT_STATUS=" + exitStatus)
+	}
+	return env
+}
+
+// Runs host commands of a hook in order, stopping at the first failure
+func runHooks(config Config, name string, cmds []string, exitStatus string) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	if ! hooksAllowed(config) {
+		pecho("warn", "Ignoring hooks." + name + ": only allowed in system or user configuration")
+		return nil
+	}
+	timeout := time.Duration(config.Hooks.Timeout) * time.Second
+	for _, cmdline := range cmds {
+		pecho("debug", "Running " + name + " hook:", cmdline)
+		ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", cmdline)
+		cmd.Env = hookEnv(config, exitStatus)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		timedOut := ctx.Err() != nil
+		cancelFunc()
+		if timedOut {
+			return errors.New(name + " hook timed out after " + timeout.String() + ": " + cmdline)
+		} else if err != nil {
+			return errors.New(name + " hook failed: " + cmdline + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Registers postStop hooks, exitStatus is the code the daemon exits with
+func registerHookStage(config Config, exitStatus int) {
+	if len(config.Hooks.PostStop) == 0 {
+		return
+	}
+	if ! preStartDone.Load() {
+		pecho("debug", "Skipping postStop hooks, the application was not started")
+		return
+	}
+	addStopHook(stagePostStop, func() {
+		err := runHooks(config, "postStop", config.Hooks.PostStop, strconv.Itoa(exitStatus))
+		if err != nil {
+			pecho("warn", err)
+		}
+	})
+}
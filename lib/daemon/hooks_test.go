@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRunHooks(t *testing.T) {
+	oldConfDir := xdgDir.confDir
+	xdgDir.confDir = t.TempDir()
+	defer func () {
+		xdgDir.confDir = oldConfDir
+	} ()
+
+	confPath := filepath.Join(xdgDir.confDir, "portable", "info", "org.example.App", "config.toml")
+	err := os.MkdirAll(filepath.Dir(confPath), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(confPath, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config Config
+	config.isModern = true
+	config.Path = confPath
+	config.Metadata.AppID = "org.example.App"
+	config.Hooks.Timeout = 5
+
+	out := filepath.Join(t.TempDir(), "out")
+	err = runHooks(config, "postStop", []string{`printf '%s %s' "$APPID" "$EXIT_STATUS" > ` + out}, "3")
+	if err != nil {
+		t.Fatal("Hook failed:", err)
+	}
+	content, _ := os.ReadFile(out)
+	if string(content) != "org.example.App 3" {
+		t.Fatal("Unexpected hook environment:", string(content))
+	}
+
+	err = runHooks(config, "preStart", []string{`printf '%s' "${EXIT_STATUS-unset}" > ` + out}, "")
+	if err != nil {
+		t.Fatal("Hook failed:", err)
+	}
+	content, _ = os.ReadFile(out)
+	if string(content) != "unset" {
+		t.Fatal("EXIT_STATUS exported to preStart:", string(content))
+	}
+
+	err = runHooks(config, "preStart", []string{"exit 1", "touch " + out + ".skipped"}, "")
+	if err == nil {
+		t.Fatal("Expected failing hook to return an error")
+	}
+	if _, err := os.Stat(out + ".skipped"); err == nil {
+		t.Fatal("Commands after a failed hook must not run")
+	}
+
+	config.Path = filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(config.Path, nil, 0600)
+	os.Remove(out)
+	runHooks(config, "postStop", []string{"touch " + out}, "0")
+	if _, err := os.Stat(out); err == nil {
+		t.Fatal("Hooks from an arbitrary path must not run")
+	}
+}
+
+func TestPostStopNeedsPreStart(t *testing.T) {
+	stopStages = newStopRegistry()
+	defer func () {
+		stopStages = newStopRegistry()
+	} ()
+	var config Config
+	config.Hooks.PostStop = []string{"true"}
+	registerHookStage(config, 1)
+	if len(stopStages.stages[stagePostStop].hooks) != 0 {
+		t.Fatal("postStop hooks registered although preStart did not succeed")
+	}
+	preStartDone.Store(true)
+	defer preStartDone.Store(false)
+	registerHookStage(config, 1)
+	if len(stopStages.stages[stagePostStop].hooks) != 1 {
+		t.Fatal("postStop hooks not registered after preStart")
+	}
+}
+
+func TestHookStateDir(t *testing.T) {
+	oldDataDir, oldRuntimeDir, oldInstanceID := xdgDir.dataDir, xdgDir.runtimeDir, runtimeInfo.instanceID
+	t.Cleanup(func() {
+		xdgDir.dataDir, xdgDir.runtimeDir, runtimeInfo.instanceID = oldDataDir, oldRuntimeDir, oldInstanceID
+	})
+	xdgDir.dataDir = "/home/test/.local/share"
+	xdgDir.runtimeDir = "/run/user/1000"
+	runtimeInfo.instanceID = "42"
+
+	var config Config
+	config.Metadata.AppID = "org.example.App"
+	config.Metadata.StateDirectory = "App"
+	if env := hookEnv(config, ""); ! slices.Contains(env, "STATE_DIR=/home/test/.local/share/App") {
+		t.Error("Unexpected state directory of the regular instance")
+	}
+	applyEphemeral(&config, []string{"--ephemeral"})
+	if env := hookEnv(config, ""); ! slices.Contains(env, "STATE_DIR=/run/user/1000/.flatpak/42-private/home") {
+		t.Error("Ephemeral instance does not point hooks at its home directory")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Host name of anonymized sandboxes, resolved by nss-myhostname
+const anonymousHostname = "localhost"
+
+// Derives a stable machine ID for the application, without revealing the host's. Like
+// sd_id128_get_machine_app_specific(), formatted as a version 4 UUID
+func anonymousMachineID(hostID string, appID string) (string, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(hostID))
+	if err != nil || len(key) != 16 {
+		return "", errors.New("malformed host machine ID")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(appID))
+	id := mac.Sum(nil)[:16]
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return hex.EncodeToString(id), nil
+}
+
+func identityDir(config Config) string {
+	return filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID)
+}
+
+// Whether the sandbox gets the generated machine ID. Minimal /etc has no other, and
+// libdbus, PulseAudio and Chromium do not work without one
+func identityMachineID(config Config) bool {
+	return config.Privacy.AnonymizeIdentity || etcMinimal(config)
+}
+
+// Random stand-in for the host machine ID, created once and kept outside of any sandbox
+func fallbackMachineKey() (string, error) {
+	path := filepath.Join(xdgDir.stateDir, "portable", "machine-id")
+	content, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+		if err == nil && len(key) == 16 {
+			return string(content), nil
+		}
+		pecho("warn", "Replacing malformed " + path)
+	} else if ! os.IsNotExist(err) {
+		return "", err
+	}
+	key := make([]byte, 16)
+	_, err = rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(key) + "\n"
+	return id, os.WriteFile(path, []byte(id), 0600)
+}
+
+// Writes the machine ID and host name files of privacy.anonymizeIdentity and minimal /etc.
+// Failing to do so stops the launch, as the host's files would show through otherwise
+func generateIdentityFiles(config Config) {
+	if config.Privacy.AnonymizeIdentity {
+		err := os.WriteFile(filepath.Join(identityDir(config), "hostname"), []byte(anonymousHostname + "\n"), 0700)
+		if err != nil {
+			pecho("crit", "Could not write fake hostname file:", err)
+			return
+		}
+	}
+	if ! identityMachineID(config) {
+		return
+	}
+	var id string
+	hostID, err := os.ReadFile("/etc/machine-id")
+	if err == nil {
+		id, err = anonymousMachineID(string(hostID), config.Metadata.AppID)
+	}
+	if err != nil {
+		pecho("warn", "Could not use host machine ID, deriving from a random one:", err)
+		var key string
+		key, err = fallbackMachineKey()
+		if err == nil {
+			id, err = anonymousMachineID(key, config.Metadata.AppID)
+		}
+	}
+	if err != nil {
+		pecho("crit", "Could not derive machine ID:", err)
+		return
+	}
+	err = os.WriteFile(filepath.Join(identityDir(config), "machine-id"), []byte(id + "\n"), 0700)
+	if err != nil {
+		pecho("crit", "Could not write fake machine ID:", err)
+	}
+}
+
+// bwrap options setting the host name, requires --unshare-uts
+func identityOpts(config Config) []string {
+	if ! config.Privacy.AnonymizeIdentity {
+		return []string{}
+	}
+	return []string{"--hostname", anonymousHostname}
+}
+
+func identityBinds(config Config) []Mount {
+	mounts := []Mount{}
+	if config.Privacy.AnonymizeIdentity {
+		mounts = append(mounts, bindMount(mountRoBind, filepath.Join(identityDir(config), "hostname"), "/etc/hostname"))
+	}
+	if identityMachineID(config) {
+		machineID := filepath.Join(identityDir(config), "machine-id")
+		mounts = append(
+			mounts,
+			bindMount(mountRoBind, machineID, "/etc/machine-id"),
+			bindMount(mountRoBind, machineID, "/var/lib/dbus/machine-id"),
+		)
+	}
+	return withPhase(mounts, mountPhasePrivacy)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnonymousMachineID(t *testing.T) {
+	hostID := "0123456789abcdef0123456789abcdef\n"
+	first, err := anonymousMachineID(hostID, "org.example.App")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := anonymousMachineID(hostID, "org.example.App")
+	other, _ := anonymousMachineID(hostID, "org.example.Other")
+	if first != second {
+		t.Error("Machine ID is not stable")
+	}
+	if first == other || first == "0123456789abcdef0123456789abcdef" {
+		t.Error("Machine ID is not specific to the application")
+	}
+	if len(first) != 32 || first[12] != '4' {
+		t.Error("Malformed machine ID " + first)
+	}
+	if _, err := anonymousMachineID("not-an-id", "org.example.App"); err == nil {
+		t.Error("Accepted malformed host machine ID")
+	}
+}
+
+func TestFallbackMachineKey(t *testing.T) {
+	oldStateDir := xdgDir.stateDir
+	xdgDir.stateDir = t.TempDir()
+	t.Cleanup(func() {
+		xdgDir.stateDir = oldStateDir
+	})
+
+	first, err := fallbackMachineKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := fallbackMachineKey()
+	if err != nil || first != second {
+		t.Error("Fallback key is not persisted:", first, second, err)
+	}
+	if _, err := anonymousMachineID(first, "org.example.App"); err != nil {
+		t.Error("Fallback key can not derive a machine ID:", err)
+	}
+
+	path := filepath.Join(xdgDir.stateDir, "portable", "machine-id")
+	if err := os.WriteFile(path, []byte("garbage\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	third, err := fallbackMachineKey()
+	if err != nil || third == first {
+		t.Error("Malformed fallback key kept:", third, err)
+	}
+	if _, err := anonymousMachineID(third, "org.example.App"); err != nil {
+		t.Error("Replaced fallback key is malformed:", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+const (
+	idlePollInterval	= 5 * time.Second
+	// CPU time per poll interval above which the sandbox counts as busy, 1% of a core
+	idleCPUThreshold	= idlePollInterval / 100
+)
+
+type idleSample struct {
+	cpu		uint64
+	// Sorted processes started by the helper, i.e. without bwrap and the helper itself
+	procs		[]string
+	// Every process of the unit
+	pids		[]string
+}
+
+// Processes of the sandbox that are not the user's
+var idleInfraComms = []string{
+	"bwrap",
+	"helper",
+}
+
+// Lists processes of a control group and its children, the application unit
+// delegates a subgroup, so its own cgroup.procs stays empty
+func cgroupTreePids(cgPath string) ([]string, error) {
+	var pids []string
+	root := filepath.Join("/sys/fs/cgroup", cgPath)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Child groups may vanish while walking
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if entry.IsDir() && entry.Name() == watchdogCgroup {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || entry.Name() != "cgroup.procs" {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		pids = append(pids, strings.Fields(string(content))...)
+		return nil
+	})
+	return pids, err
+}
+
+// Filters out processes belonging to the sandbox itself
+func userProcs(pids []string) []string {
+	var procs []string
+	for _, pid := range pids {
+		comm, err := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+		if err != nil || slices.Contains(idleInfraComms, strings.TrimSpace(string(comm))) {
+			continue
+		}
+		procs = append(procs, pid)
+	}
+	slices.Sort(procs)
+	return procs
+}
+
+func sampleUnit(ctx context.Context, conn *dbus.Conn, unit string) (idleSample, bool) {
+	props, err := conn.GetAllPropertiesContext(ctx, unit)
+	if err != nil {
+		pecho("debug", "Could not query " + unit + ":", err)
+		return idleSample{}, false
+	}
+	cpu, okCPU := props["CPUUsageNSec"].(uint64)
+	cgPath := parseStr(props["ControlGroup"])
+	if ! okCPU || len(cgPath) == 0 {
+		return idleSample{}, false
+	}
+	pids, err := cgroupTreePids(cgPath)
+	if err != nil {
+		return idleSample{}, false
+	}
+	return idleSample{cpu: cpu, procs: userProcs(pids), pids: pids}, true
+}
+
+// Returns why the sandbox is busy, or an empty string if it is idle since prev. Any
+// process besides bwrap and the helper keeps the sandbox busy, so only sandboxes left
+// behind by their application, e.g. with processes.track disabled, become idle.
+// trayRegistered is only called if nothing else keeps the sandbox busy
+func busyReason(prev idleSample, cur idleSample, trayRegistered func() bool) string {
+	if cur.cpu < prev.cpu {
+		return "application restarted"
+	}
+	if time.Duration(cur.cpu - prev.cpu) > idleCPUThreshold {
+		return "CPU usage"
+	}
+	if len(cur.procs) > 0 {
+		return "user processes running"
+	}
+	if ! slices.Equal(prev.procs, cur.procs) {
+		return "processes changed"
+	}
+	if trayRegistered() {
+		return "tray icon registered"
+	}
+	return ""
+}
+
+// Stops the sandbox once it has been idle for processes.idleTimeout seconds
+func idleWatcher(conn *dbus.Conn, config Config, stopSig chan int) {
+	if config.Processes.IdleTimeout <= 0 {
+		return
+	}
+	busConn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("warn", "Could not connect to session bus, idle timeout disabled:", err)
+		return
+	}
+	timeout := time.Duration(config.Processes.IdleTimeout) * time.Second
+	appUnit := appUnitName(config)
+	proxyUnit := proxyUnitName(config)
+	ticker := time.NewTicker(idlePollInterval)
+	defer ticker.Stop()
+
+	var prev idleSample
+	var hasPrev bool
+	idleSince := time.Now()
+	for range ticker.C {
+		if stopRequested.Load() {
+			return
+		}
+		ctx, cancelFunc := context.WithTimeout(context.Background(), idlePollInterval)
+		cur, ok := sampleUnit(ctx, conn, appUnit)
+		var proxyPids []string
+		if proxy, ok := sampleUnit(ctx, conn, proxyUnit); ok {
+			proxyPids = proxy.pids
+		}
+		cancelFunc()
+		if ! ok {
+			// Not started yet or restarting
+			hasPrev = false
+			idleSince = time.Now()
+			continue
+		}
+		if hasPrev {
+			trayRegistered := func() bool {
+				names, err := trayItemsOf(busConn, append(proxyPids, cur.pids...))
+				return err == nil && len(names) > 0
+			}
+			if reason := busyReason(prev, cur, trayRegistered); len(reason) > 0 {
+				pecho("debug", "Sandbox is busy: " + reason)
+				idleSince = time.Now()
+			}
+		}
+		prev = cur
+		hasPrev = true
+		if time.Since(idleSince) >= timeout {
+			pecho(
+				"info",
+				"Stopping sandbox: idle for " + strconv.Itoa(config.Processes.IdleTimeout) + " seconds",
+			)
+			stopSig <- 0
+			return
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusyReason(t *testing.T) {
+	busyCPU := uint64(idleCPUThreshold + time.Millisecond)
+	for _, tc := range []struct {
+		name		string
+		prev		idleSample
+		cur		idleSample
+		tray		bool
+		want		string
+	}{
+		{"idle", idleSample{cpu: 1000}, idleSample{cpu: 1100}, false, ""},
+		{"quiet process", idleSample{cpu: 1000, procs: []string{"2"}}, idleSample{cpu: 1000, procs: []string{"2"}}, false, "user processes running"},
+		{"cpu", idleSample{cpu: 1000}, idleSample{cpu: 1000 + busyCPU}, false, "CPU usage"},
+		{"counter reset", idleSample{cpu: 1000 + busyCPU}, idleSample{cpu: 10}, false, "application restarted"},
+		{"process started", idleSample{}, idleSample{procs: []string{"7"}}, false, "user processes running"},
+		{"process exited", idleSample{procs: []string{"2"}}, idleSample{}, false, "processes changed"},
+		{"tray", idleSample{}, idleSample{}, true, "tray icon registered"},
+	} {
+		got := busyReason(tc.prev, tc.cur, func() bool { return tc.tray })
+		if got != tc.want {
+			t.Error(tc.name + ": got \"" + got + "\", want \"" + tc.want + "\"")
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+type inputMethod struct {
+	Name		string
+	// Substrings of XMODIFIERS, QT_IM_MODULE or GTK_IM_MODULE identifying the input method
+	EnvMarkers	[]string
+	// Bus names owned while the input method runs. kime, uim, hime and gcin talk over
+	// their own sockets or X11 instead, so they are only detected by EnvMarkers
+	BusNames	[]string
+	// Names the sandbox may talk to through the D-Bus proxy
+	Talks		[]string
+	// Variables for X11 sessions, Wayland uses the text-input protocol instead
+	Envs		[]string
+}
+
+var inputMethods = []inputMethod{
+	{
+		Name:		"fcitx",
+		EnvMarkers:	[]string{"fcitx"},
+		BusNames:	[]string{"org.fcitx.Fcitx5", "org.freedesktop.portal.Fcitx", "org.fcitx.Fcitx"},
+		Talks:		[]string{"org.freedesktop.portal.Fcitx"},
+		Envs:		[]string{"QT_IM_MODULE=fcitx", "QT_IM_MODULES=wayland;fcitx"},
+	},
+	{
+		Name:		"ibus",
+		EnvMarkers:	[]string{"ibus"},
+		BusNames:	[]string{"org.freedesktop.IBus", "org.freedesktop.portal.IBus"},
+		Talks:		[]string{"org.freedesktop.portal.IBus"},
+		Envs:		[]string{"QT_IM_MODULE=ibus", "QT_IM_MODULES=wayland;ibus"},
+	},
+	{
+		Name:		"kime",
+		EnvMarkers:	[]string{"kime"},
+		Envs:		[]string{"QT_IM_MODULE=kime", "GTK_IM_MODULE=kime"},
+	},
+	{
+		Name:		"uim",
+		EnvMarkers:	[]string{"uim"},
+		Envs:		[]string{"QT_IM_MODULE=uim", "GTK_IM_MODULE=uim"},
+	},
+	{
+		Name:		"hime",
+		EnvMarkers:	[]string{"hime"},
+		Envs:		[]string{"QT_IM_MODULE=hime", "GTK_IM_MODULE=hime"},
+	},
+	{
+		Name:		"gcin",
+		EnvMarkers:	[]string{"gcin"},
+		Talks:		[]string{"org.freedesktop.portal.IBus"},
+		Envs:		[]string{"QT_IM_MODULES=wayland;ibus", "QT_IM_MODULE=ibus", "GTK_IM_MODULE=gcin"},
+	},
+}
+
+var (
+	imOnce		sync.Once
+	imDetected	*inputMethod
+)
+
+func lookupInputMethod(name string) *inputMethod {
+	for idx := range inputMethods {
+		if inputMethods[idx].Name == name {
+			return &inputMethods[idx]
+		}
+	}
+	return nil
+}
+
+func busNameOwned(conn *godbus.Conn, name string) bool {
+	var owned bool
+	err := conn.BusObject().Call(
+		"org.freedesktop.DBus.NameHasOwner",
+		godbus.FlagNoAutoStart,
+		name,
+	).Store(&owned)
+	if err != nil {
+		pecho("debug", "Could not query owner of " + name + ":", err)
+		return false
+	}
+	return owned
+}
+
+// Determines the input method in use, honouring privacy.inputMethod. Returns nil if none is found
+func detectInputMethod(config Config) *inputMethod {
+	imOnce.Do(func() {
+		switch config.Privacy.InputMethod {
+			case "none":
+				return
+			case "", "auto":
+			default:
+				imDetected = lookupInputMethod(config.Privacy.InputMethod)
+				if imDetected == nil {
+					pecho("warn", "Unknown input method " + config.Privacy.InputMethod)
+				}
+				return
+		}
+		for _, env := range []string{"XMODIFIERS", "QT_IM_MODULE", "GTK_IM_MODULE"} {
+			val := os.Getenv(env)
+			for idx, im := range inputMethods {
+				for _, marker := range im.EnvMarkers {
+					if strings.Contains(val, marker) {
+						imDetected = &inputMethods[idx]
+						return
+					}
+				}
+			}
+		}
+		conn, err := godbus.SessionBus()
+		if err != nil {
+			pecho("warn", "Could not detect input method:", err)
+			return
+		}
+		for idx, im := range inputMethods {
+			for _, name := range im.BusNames {
+				if busNameOwned(conn, name) {
+					imDetected = &inputMethods[idx]
+					return
+				}
+			}
+		}
+	})
+	return imDetected
+}
+
+// D-Bus proxy rules for the input method, all known ones when auto detection found nothing
+func imTalkArgs(config Config) []string {
+	var talks []string
+	if im := detectInputMethod(config); im != nil {
+		talks = im.Talks
+	} else if config.Privacy.InputMethod == "auto" {
+		for _, im := range inputMethods {
+			talks = append(talks, im.Talks...)
+		}
+	}
+	var args []string
+	for _, talkDest := range slices.Compact(slices.Sorted(slices.Values(talks))) {
+		args = append(
+			args,
+			"--talk=" + talkDest,
+			"--call=" + talkDest + "=*",
+		)
+	}
+	return args
+}
+
+func imEnvs(config Config) {
+	if config.Privacy.InputMethod == "none" {
+		return
+	}
+	addEnv("IBUS_USE_PORTAL=1")
+	if ! config.Privacy.X11 {
+		addEnv("QT_IM_MODULE=wayland")
+		addEnv("GTK_IM_MODULE=wayland")
+		return
+	}
+	im := detectInputMethod(config)
+	if im == nil {
+		pecho("warn", "Could not determine input method")
+		return
+	}
+	pecho("debug", "Determined input method type: " + im.Name)
+	for _, env := range im.Envs {
+		addEnv(env)
+	}
+}
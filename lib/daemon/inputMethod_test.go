@@ -0,0 +1,26 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestImTalkArgs(t *testing.T) {
+	var config Config
+	for name, want := range map[string][]string{
+		"none":		nil,
+		"kime":		nil,
+		"fcitx":	{"--talk=org.freedesktop.portal.Fcitx", "--call=org.freedesktop.portal.Fcitx=*"},
+		"gcin":		{"--talk=org.freedesktop.portal.IBus", "--call=org.freedesktop.portal.IBus=*"},
+	} {
+		imOnce = sync.Once{}
+		imDetected = nil
+		config.Privacy.InputMethod = name
+		if got := imTalkArgs(config); ! slices.Equal(got, want) {
+			t.Error("imTalkArgs for " + name + " returned", got)
+		}
+	}
+	imOnce = sync.Once{}
+	imDetected = nil
+}
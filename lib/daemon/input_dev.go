@@ -17,47 +17,41 @@ func enumerateDevices(subsystem string) ([]*udev.Device, error) {
 	return devs, nil
 }
 
-func collectDevices(devs []*udev.Device, inputBindChan chan []string) {
+func collectDevices(devs []*udev.Device, inputBindChan chan []Mount) {
 	var wg sync.WaitGroup
 	defer wg.Wait()
 	for _, dev := range devs {
 		device := dev
 		wg.Go(func() {
 			if path := device.Syspath(); len(path) > 0 {
-				inputBindChan <- []string{
-					"--dev-bind",
-					path,
-					path,
+				inputBindChan <- []Mount{
+					bindMount(mountDevBind, path, path),
 				}
 			}
 
 			if devName := device.PropertyValue("DEVNAME"); len(devName) > 0 {
-				inputBindChan <- []string{
-					"--dev-bind",
-					devName,
-					devName,
+				inputBindChan <- []Mount{
+					bindMount(mountDevBind, devName, devName),
 				}
 			}
 			devlinks := device.Devlinks()
 			for k := range devlinks {
-				inputBindChan <- []string{
-					"--dev-bind",
-					k,
-					k,
+				inputBindChan <- []Mount{
+					bindMount(mountDevBind, k, k),
 				}
 			}
 		})
 	}
 }
 
-func inputBind(inputBindChan chan []string) {
+func inputBind(inputBindChan chan []Mount) {
 	var wg sync.WaitGroup
-	inputBindChan <- []string{
-		"--dev-bind-try",	"/sys/class/leds", "/sys/class/leds",
-		"--dev-bind-try",	"/sys/class/input", "/sys/class/input",
-		"--dev-bind-try",	"/sys/class/hidraw", "/sys/class/hidraw",
-		"--dev-bind-try",	"/dev/input", "/dev/input",
-		"--dev-bind-try",	"/dev/uinput", "/dev/uinput",
+	inputBindChan <- []Mount{
+		tryMount(mountDevBind, "/sys/class/leds", "/sys/class/leds"),
+		tryMount(mountDevBind, "/sys/class/input", "/sys/class/input"),
+		tryMount(mountDevBind, "/sys/class/hidraw", "/sys/class/hidraw"),
+		tryMount(mountDevBind, "/dev/input", "/dev/input"),
+		tryMount(mountDevBind, "/dev/uinput", "/dev/uinput"),
 	}
 
 	wg.Go(func() {
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const zoneInfoDir = "/usr/share/zoneinfo"
+
+// Whether a host variable is replaced by a per-application locale or timezone override
+func localeOverridden(config Config, name string) bool {
+	if len(config.Locale.Language) > 0 {
+		if name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_") {
+			return true
+		}
+	}
+	return len(config.Locale.Timezone) > 0 && name == "TZ"
+}
+
+// Resolves a timezone name like Asia/Shanghai to its zone file
+func zoneFile(timezone string) (string, error) {
+	if ! filepath.IsLocal(timezone) {
+		return "", errors.New("invalid timezone " + timezone)
+	}
+	path := filepath.Join(zoneInfoDir, timezone)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	} else if stat.IsDir() {
+		return "", errors.New("invalid timezone " + timezone + ": is a directory")
+	}
+	return path, nil
+}
+
+func localeEnvs(config Config) {
+	if lang := config.Locale.Language; len(lang) > 0 {
+		addEnv("LANG=" + lang)
+		// LANGUAGE takes a list of languages without encoding, e.g. en_US
+		language, _, _ := strings.Cut(lang, ".")
+		language, _, _ = strings.Cut(language, "@")
+		addEnv("LANGUAGE=" + language)
+	}
+	if tz := config.Locale.Timezone; len(tz) > 0 {
+		if _, err := zoneFile(tz); err != nil {
+			pecho("warn", "Ignoring timezone override:", err)
+			return
+		}
+		addEnv("TZ=:" + filepath.Join(zoneInfoDir, tz))
+	}
+}
+
+// Binds the zone file of the host, or the override, at /etc/localtime
+func localtimeBind(config Config) []Mount {
+	var src string
+	if tz := config.Locale.Timezone; len(tz) > 0 {
+		path, err := zoneFile(tz)
+		if err != nil {
+			pecho("warn", "Ignoring timezone override:", err)
+		} else {
+			src = path
+		}
+	}
+	if len(src) == 0 {
+		path, err := filepath.EvalSymlinks("/etc/localtime")
+		if err != nil {
+			pecho("debug", "Could not resolve host timezone:", err)
+			return []Mount{}
+		}
+		src = path
+	}
+	return []Mount{tryMount(mountRoBind, src, "/etc/localtime")}
+}
@@ -1,17 +1,28 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/coreos/go-systemd/v22/journal"
 	"golang.org/x/term"
 )
 
 var (
 	pechoChan		= make(chan pechoMsg, 128)
+	// Fields attached to every log record, see setLogField
+	logFields		sync.Map
 )
 
+// Critical messages abort the start sequence, see pechoWorker
+const levelCrit = slog.LevelError + 4
+
 type pechoMsg struct {
 	level		string
 	msg		[]any
@@ -24,131 +35,221 @@ func pecho(level string, message ...any) {
 	}
 }
 
+// Sets a field attached to all following log records, e.g. APPID, INSTANCE_ID or PHASE
+func setLogField(key string, value string) {
+	logFields.Store(key, value)
+}
 
-func pechoWorker(stopSig chan int) {
-	var trueColor bool
-	if os.Getenv("COLORTERM") == "truecolor" {
-		// See https://en.wikipedia.org/wiki/ANSI_escape_code#Unix_environment_variables_relating_to_color_support
-		trueColor = true
+func logAttrs() []slog.Attr {
+	var attrs []slog.Attr
+	logFields.Range(func(key, value any) bool {
+		attrs = append(attrs, slog.String(key.(string), value.(string)))
+		return true
+	})
+	return attrs
+}
+
+// Prints records like the classic console output, with coloured level prefixes
+type consoleHandler struct {
+	out		io.Writer
+	level		slog.Leveler
+	prefixes	map[slog.Level]string
+	lock		*sync.Mutex
+}
+
+func newConsoleHandler(out io.Writer, level slog.Leveler, trueColor bool) *consoleHandler {
+	const reset = "\033[0m"
+	var colors = map[slog.Level]string{
+		slog.LevelDebug:	"\033[38;2;125;241;118m",
+		slog.LevelInfo:		"\033[38;2;119;222;250m",
+		slog.LevelWarn:		"\033[38;2;255;209;59m",
+		levelCrit:		"\033[38;2;255;0;0m",
+	}
+	_, month, day := time.Now().Date()
+	if month == time.December && day == 25 {
+		for _, lvl := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn} {
+			colors[lvl] = "\033[38;2;213;161;115m"
+		}
+	}
+	var names = map[slog.Level]string{
+		slog.LevelDebug:	"[Debug]	",
+		slog.LevelInfo:		"[Info]	",
+		slog.LevelWarn:		"[Warn]	",
+		levelCrit:		"[Critical]	",
+	}
+	h := &consoleHandler{
+		out:		out,
+		level:		level,
+		prefixes:	map[slog.Level]string{},
+		lock:		&sync.Mutex{},
+	}
+	for lvl, name := range names {
+		if trueColor {
+			h.prefixes[lvl] = reset + colors[lvl] + name + reset
+		} else {
+			h.prefixes[lvl] = reset + name + reset
+		}
+	}
+	return h
+}
+
+func (h *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	_, err := io.WriteString(h.out, h.prefixes[r.Level] + r.Message + "\n")
+	return err
+}
+
+// Fields are only useful in the journal or JSON, the console stays terse
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+// Sends records to the journal natively, attributes become journal fields
+type journalHandler struct {
+	level		slog.Leveler
+	attrs		[]slog.Attr
+}
+
+func journalPriority(level slog.Level) journal.Priority {
+	switch {
+		case level >= levelCrit:
+			return journal.PriCrit
+		case level >= slog.LevelError:
+			return journal.PriErr
+		case level >= slog.LevelWarn:
+			return journal.PriWarning
+		case level >= slog.LevelInfo:
+			return journal.PriInfo
+		default:
+			return journal.PriDebug
+	}
+}
+
+// Journal field names may only contain upper case letters, digits and underscores
+func journalField(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				return r
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			default:
+				return '_'
+		}
+	}, key)
+}
+
+func (h *journalHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(ctx context.Context, r slog.Record) error {
+	vars := map[string]string{
+		"SYSLOG_IDENTIFIER":	"portable",
+	}
+	add := func(attr slog.Attr) bool {
+		vars[journalField(attr.Key)] = attr.Value.String()
+		return true
 	}
+	for _, attr := range h.attrs {
+		add(attr)
+	}
+	r.Attrs(add)
+	return journal.Send(r.Message, journalPriority(r.Level), vars)
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &journalHandler{
+		level:	h.level,
+		attrs:	append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+// Builds the logger according to PORTABLE_LOGGING_FORMAT, all output goes to stderr
+func newLogger(format string, level slog.Level) *slog.Logger {
+	trueColor := os.Getenv("COLORTERM") == "truecolor"
+	// See https://en.wikipedia.org/wiki/ANSI_escape_code#Unix_environment_variables_relating_to_color_support
 	if len(os.Getenv("NO_COLOR")) > 0 {
 		trueColor = false
-		pecho("debug", "Disabled coloured output in response to NO_COLOR")
-	} else if ! term.IsTerminal(int(os.Stdout.Fd())) {
-		trueColor = false
-		pecho("debug", "Disabled coloured output for non-terminal stdout")
 	} else if ! term.IsTerminal(int(os.Stderr.Fd())) {
 		trueColor = false
-		pecho("debug", "Disabled coloured output for non-terminal stderr")
 	}
+	switch format {
+		case "journald", "journal":
+			if journal.Enabled() {
+				return slog.New(&journalHandler{level: level})
+			}
+			fmt.Fprintln(os.Stderr, "Journal is not available, logging to console")
+		case "json":
+			return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+				Level:		level,
+				ReplaceAttr:	func(groups []string, attr slog.Attr) slog.Attr {
+					if attr.Key == slog.LevelKey && attr.Value.Any() == levelCrit {
+						attr.Value = slog.StringValue("CRIT")
+					}
+					return attr
+				},
+			}))
+	}
+	return slog.New(newConsoleHandler(os.Stderr, level, trueColor))
+}
+
+func pechoWorker(stopSig chan int) {
+	var level slog.Level
 	var externalLoggingLevel = os.Getenv("PORTABLE_LOGGING")
 	switch externalLoggingLevel {
 		case "debug":
 			internalLoggingLevel = 1
+			level = slog.LevelDebug
 		case "info":
 			internalLoggingLevel = 2
-		case "warn":
-			internalLoggingLevel = 3
+			level = slog.LevelInfo
 		default:
 			internalLoggingLevel = 3
+			level = slog.LevelWarn
 	}
-	var debugLogger *log.Logger
-	var critLogger *log.Logger
-	var warnLogger *log.Logger
-	var infoLogger *log.Logger
-
-	if trueColor {
-		debugLogger = log.New(
-			os.Stdout,
-			"\033[0m" + "\033[38;2;125;241;118m" + "[Debug]	" + "\033[0m",
-			0,
-		)
-		critLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "\033[38;2;255;0;0m" + "[Critical]	" + "\033[0m",
-			0,
-		)
-		warnLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "\033[38;2;255;209;59m" + "[Warn]	" + "\033[0m",
-			0,
-		)
-		infoLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "\033[38;2;119;222;250m" + "[Info]	" + "\033[0m",
-			0,
-		)
-	} else {
-		debugLogger = log.New(
-			os.Stdout,
-			"\033[0m" + "[Debug]	" + "\033[0m",
-			0,
-		)
-		critLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "[Critical]	" + "\033[0m",
-			0,
-		)
-		warnLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "[Warn]	" + "\033[0m",
-			0,
-		)
-		infoLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "[Info]	" + "\033[0m",
-			0,
-		)
-	}
-
-	_, month, day := time.Now().Date()
-	if trueColor && month == time.December && day == 25 {
-		debugLogger = log.New(
-			os.Stdout,
-			"\033[0m" + "\033[38;2;213;161;115m" + "[Debug]	" + "\033[0m",
-			0,
-		)
-		critLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "\033[38;2;255;0;0m" + "[Critical]	" + "\033[0m",
-			0,
-		)
-		warnLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "\033[38;2;213;161;115m" + "[Warn]	" + "\033[0m",
-			0,
-		)
-		infoLogger = log.New(
-			os.Stderr,
-			"\033[0m" + "\033[38;2;213;161;115m" + "[Info]	" + "\033[0m",
-			0,
-		)
-	}
+	logger := newLogger(os.Getenv("PORTABLE_LOGGING_FORMAT"), level)
+	ctx := context.Background()
 
 	for {
 		chanRes := <- pechoChan
+		var lvl slog.Level
 		switch chanRes.level {
 			case "debug":
-				if internalLoggingLevel <= 1 {
-					debugLogger.Println(chanRes.msg)
-				}
+				lvl = slog.LevelDebug
 			case "info":
-				if internalLoggingLevel <= 2 {
-					infoLogger.Println(chanRes.msg)
-				}
+				lvl = slog.LevelInfo
 			case "warn":
-				warnLogger.Println(chanRes.msg)
+				lvl = slog.LevelWarn
 			case "crit":
-				critLogger.Println(chanRes.msg)
-				select {
-					case stopSig <- 1:
-					default:
-						critLogger.Fatalln(
-							"This critical error happened before stopper initialisation",
-						)
-				}
+				lvl = levelCrit
 			default:
 				pecho("crit", "Unknown message level for", chanRes.msg)
+				continue
+		}
+		msg := strings.TrimSuffix(fmt.Sprintln(chanRes.msg...), "\n")
+		logger.LogAttrs(ctx, lvl, msg, logAttrs()...)
+		if lvl != levelCrit {
+			continue
+		}
+		select {
+			case stopSig <- 1:
+			default:
+				fmt.Fprintln(os.Stderr, "This critical error happened before stopper initialisation")
+				os.Exit(1)
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestConsoleHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newConsoleHandler(&buf, slog.LevelInfo, false))
+	logger.LogAttrs(context.Background(), slog.LevelDebug, "hidden")
+	logger.LogAttrs(context.Background(), levelCrit, "broken", slog.String("APPID", "org.example.App"))
+	if buf.String() != "\033[0m[Critical]	\033[0mbroken\n" {
+		t.Fatalf("Unexpected console output: %q", buf.String())
+	}
+}
+
+func TestJournalField(t *testing.T) {
+	for key, want := range map[string]string{
+		"APPID":	"APPID",
+		"instance-id":	"INSTANCE_ID",
+		"phase":	"PHASE",
+	} {
+		if got := journalField(key); got != want {
+			t.Fatal("Expected", want, "got", got)
+		}
+	}
+}
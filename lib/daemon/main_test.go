@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// Discards log messages, nothing else reads pechoChan during tests
+func TestMain(m *testing.M) {
+	go func () {
+		for range pechoChan {}
+	} ()
+	os.Exit(m.Run())
+}
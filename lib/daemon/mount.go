@@ -0,0 +1,342 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Values are the bwrap options creating the mount
+type mountKind string
+
+const (
+	mountBind	mountKind = "--bind"
+	mountRoBind	mountKind = "--ro-bind"
+	mountDevBind	mountKind = "--dev-bind"
+	mountTmpfs	mountKind = "--tmpfs"
+	mountDir	mountKind = "--dir"
+	mountSymlink	mountKind = "--symlink"
+	mountRoOverlay	mountKind = "--ro-overlay"
+	mountDev	mountKind = "--dev"
+	mountProc	mountKind = "--proc"
+	mountMqueue	mountKind = "--mqueue"
+)
+
+// Mounts are applied phase by phase, so later phases override earlier ones
+type mountPhase int
+
+const (
+	// Root file system, sockets and the home directory
+	mountPhaseBase	mountPhase = iota
+	// Directories hidden by empty tmpfs
+	mountPhaseMask
+	// Device nodes and their sysfs entries
+	mountPhaseDevice
+	// Paths exposed by the user
+	mountPhaseUser
+	// Overrides hiding host information, applied last so nothing can undo them
+	mountPhasePrivacy
+)
+
+// A single entry of the sandbox file system layout
+type Mount struct {
+	Kind		mountKind
+	Phase		mountPhase
+	// Source on the host, or target of a symbolic link
+	Src		string
+	Dest		string
+	// Skip binds whose source does not exist
+	Try		bool
+	// Octal permissions of the created file or directory, e.g. 0755
+	Perms		string
+	// Lower directories of an overlay, lowest first
+	Overlay		[]string
+	// Requested by the user, e.g. via --expose, and checked against protected paths
+	User		bool
+}
+
+// Destinations user mounts may not cover or replace
+var mountProtected = []string{
+	"/usr",
+	"/proc",
+	"/sys",
+	"/dev",
+	"/etc",
+	"/bin",
+	"/sbin",
+	"/lib",
+	"/lib64",
+	"/boot",
+	"/run",
+	"/top.kimiblock.portable",
+}
+
+func bindMount(kind mountKind, src string, dest string) Mount {
+	return Mount{Kind: kind, Src: src, Dest: dest}
+}
+
+// Like bindMount, but skipped by bwrap if src does not exist
+func tryMount(kind mountKind, src string, dest string) Mount {
+	return Mount{Kind: kind, Src: src, Dest: dest, Try: true}
+}
+
+func tmpfsMount(dest string) Mount {
+	return Mount{Kind: mountTmpfs, Dest: dest}
+}
+
+func symlinkMount(target string, dest string) Mount {
+	return Mount{Kind: mountSymlink, Src: target, Dest: dest}
+}
+
+func withPhase(mounts []Mount, phase mountPhase) []Mount {
+	for idx := range mounts {
+		mounts[idx].Phase = phase
+	}
+	return mounts
+}
+
+// Serializes the mount to bwrap arguments
+func (m Mount) args() []string {
+	var args []string
+	if len(m.Perms) > 0 {
+		args = append(args, "--perms", m.Perms)
+	}
+	switch m.Kind {
+		case mountBind, mountRoBind, mountDevBind:
+			opt := string(m.Kind)
+			if m.Try {
+				opt = opt + "-try"
+			}
+			args = append(args, opt, m.Src, m.Dest)
+		case mountSymlink:
+			args = append(args, string(m.Kind), m.Src, m.Dest)
+		case mountRoOverlay:
+			for _, src := range m.Overlay {
+				args = append(args, "--overlay-src", src)
+			}
+			args = append(args, string(m.Kind), m.Dest)
+		default:
+			args = append(args, string(m.Kind), m.Dest)
+	}
+	return args
+}
+
+func (m Mount) String() string {
+	return strings.Join(m.args(), " ")
+}
+
+// Whether path is dir or inside of it
+func pathWithin(path string, dir string) bool {
+	if dir == "/" {
+		return true
+	}
+	return path == dir || strings.HasPrefix(path, dir + "/")
+}
+
+// Checks user mounts against protected destinations. Device binds may target nodes under /dev
+func mountProtectedErr(m Mount) error {
+	if m.Dest == "/" {
+		return errors.New("Refusing to mount " + m.Src + " over the root directory")
+	}
+	for _, dir := range mountProtected {
+		if ! pathWithin(m.Dest, dir) {
+			continue
+		}
+		if m.Kind == mountDevBind && dir == "/dev" && m.Dest != dir {
+			continue
+		}
+		return errors.New("Refusing to mount " + m.Src + " at protected path " + m.Dest)
+	}
+	return nil
+}
+
+// Collects mounts from concurrent producers and validates the resulting layout
+type mountBuilder struct {
+	lock		sync.Mutex
+	mounts		[]Mount
+}
+
+func (b *mountBuilder) add(mounts ...Mount) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, m := range mounts {
+		m.Dest = filepath.Clean(m.Dest)
+		if m.User {
+			m.Phase = mountPhaseUser
+		}
+		b.mounts = append(b.mounts, m)
+	}
+}
+
+// Orders by phase, then parents before their children. The remaining keys
+// only make the order independent of how producers delivered the mounts,
+// same destinations are resolved by build
+func compareMounts(a Mount, b Mount) int {
+	return cmp.Or(
+		cmp.Compare(a.Phase, b.Phase),
+		cmp.Compare(strings.Count(a.Dest, "/"), strings.Count(b.Dest, "/")),
+		strings.Compare(a.Dest, b.Dest),
+		strings.Compare(a.String(), b.String()),
+	)
+}
+
+// Picks between two built-in mounts at the same destination, prev coming first in order.
+// Later phases override earlier ones, and within a phase a required mount replaces an
+// optional one. Anything else is a bug in the producers
+func resolveMountConflict(prev Mount, m Mount) (Mount, error) {
+	switch {
+		case m.Phase > prev.Phase:
+			return m, nil
+		case prev.Try && ! m.Try:
+			return m, nil
+		case m.Try && ! prev.Try:
+			return prev, nil
+	}
+	return prev, errors.New("Conflicting mounts at " + m.Dest + ": " + prev.String() + " and " + m.String())
+}
+
+// Returns the deduplicated layout in application order. Conflicting user mounts
+// are dropped with a warning, conflicting built-in mounts are resolved by
+// resolveMountConflict and fail the build if they can not be
+func (b *mountBuilder) build() ([]Mount, []error, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var errs []error
+	var fatal []error
+	var res []Mount
+	seen := map[string]bool{}
+	byDest := map[string]int{}
+	sorted := slices.Clone(b.mounts)
+	slices.SortStableFunc(sorted, compareMounts)
+	for _, m := range sorted {
+		if m.User {
+			continue
+		}
+		key := m.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if idx, ok := byDest[m.Dest]; ok {
+			kept, err := resolveMountConflict(res[idx], m)
+			if err != nil {
+				fatal = append(fatal, err)
+				continue
+			}
+			pecho("debug", "Mount " + kept.String() + " takes precedence at " + m.Dest)
+			res[idx] = kept
+			continue
+		}
+		byDest[m.Dest] = len(res)
+		res = append(res, m)
+	}
+	systemCnt := len(res)
+	for _, m := range sorted {
+		if ! m.User {
+			continue
+		}
+		key := m.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if err := mountProtectedErr(m); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if idx, ok := byDest[m.Dest]; ok {
+			errs = append(errs, errors.New("Refusing to mount " + m.Src + " at " + m.Dest + ": destination taken by " + res[idx].String()))
+			continue
+		}
+		var covered string
+		for _, sys := range res[:systemCnt] {
+			if sys.Dest != m.Dest && pathWithin(sys.Dest, m.Dest) {
+				covered = sys.Dest
+				break
+			}
+		}
+		if len(covered) > 0 {
+			errs = append(errs, errors.New("Refusing to mount " + m.Src + " at " + m.Dest + ": it would cover " + covered))
+			continue
+		}
+		byDest[m.Dest] = len(res)
+		res = append(res, m)
+	}
+	slices.SortStableFunc(res, compareMounts)
+	return res, errs, errors.Join(fatal...)
+}
+
+// Digest of the layout, with the instance ID masked so launches can be compared
+func layoutHash(mounts []Mount, instanceID string) string {
+	hash := sha256.New()
+	for _, m := range mounts {
+		for _, arg := range m.args() {
+			parts := strings.Split(arg, "/")
+			for idx, part := range parts {
+				if len(instanceID) > 0 && (part == instanceID || part == instanceID + "-private") {
+					parts[idx] = strings.Replace(part, instanceID, "<instance>", 1)
+				}
+			}
+			hash.Write([]byte(strings.Join(parts, "/")))
+			hash.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Device nodes bound into the layout, as opposed to the classes requested
+func deviceNodes(mounts []Mount) []string {
+	var nodes []string
+	for _, m := range mounts {
+		switch m.Kind {
+			case mountBind, mountRoBind, mountDevBind:
+			default:
+				continue
+		}
+		if m.Phase == mountPhaseDevice && pathWithin(m.Dest, "/dev") && ! slices.Contains(nodes, m.Dest) {
+			nodes = append(nodes, m.Dest)
+		}
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// Serializes the validated layout to bwrap arguments, reporting conflicts.
+// Also returns the layout itself
+func (b *mountBuilder) args() ([]string, []Mount, error) {
+	mounts, errs, err := b.build()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, err := range errs {
+		pecho("warn", "Mount conflict:", err)
+	}
+	var args []string
+	for _, m := range mounts {
+		args = append(args, m.args()...)
+	}
+	return args, mounts, nil
+}
+
+// Writes bwrap arguments NUL separated, as expected by its --args option. The file lives
+// outside of the sandbox, so neither the unit nor process listings reveal host paths
+func writeBwrapArgs(args []string) (string, error) {
+	path := filepath.Join(xdgDir.runtimeDir, ".flatpak", runtimeInfo.instanceID + "-private", "bwrap-args")
+	var builder strings.Builder
+	for _, arg := range args {
+		builder.WriteString(arg)
+		builder.WriteByte(0)
+	}
+	err := os.WriteFile(path + ".tmp", []byte(builder.String()), 0600)
+	if err != nil {
+		return "", err
+	}
+	pecho("debug", "Wrote bwrap arguments to " + path)
+	return path, os.Rename(path + ".tmp", path)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMountArgs(t *testing.T) {
+	for _, tc := range []struct {
+		mount		Mount
+		want		[]string
+	}{
+		{bindMount(mountRoBind, "/usr", "/usr"), []string{"--ro-bind", "/usr", "/usr"}},
+		{tryMount(mountDevBind, "/dev/mali", "/dev/mali"), []string{"--dev-bind-try", "/dev/mali", "/dev/mali"}},
+		{Mount{Kind: mountTmpfs, Dest: "/boot", Perms: "0000"}, []string{"--perms", "0000", "--tmpfs", "/boot"}},
+		{symlinkMount("/usr/lib", "/lib"), []string{"--symlink", "/usr/lib", "/lib"}},
+		{
+			Mount{Kind: mountRoOverlay, Dest: "/usr/bin", Overlay: []string{"/usr/bin", "/opt/bin"}},
+			[]string{"--overlay-src", "/usr/bin", "--overlay-src", "/opt/bin", "--ro-overlay", "/usr/bin"},
+		},
+	} {
+		if got := tc.mount.args(); ! slices.Equal(got, tc.want) {
+			t.Error("Mount serialized to", got, "want", tc.want)
+		}
+	}
+}
+
+func TestMountBuilder(t *testing.T) {
+	var builder mountBuilder
+	builder.add(
+		bindMount(mountRoBind, "/usr", "/usr"),
+		tmpfsMount("/tmp"),
+		bindMount(mountBind, "/state", "/home/user"),
+		symlinkMount("/sys/devices/card0", "/dev/dri/by-path/card"),
+		symlinkMount("/sys/devices/card0", "/dev/dri/by-path/card"),
+	)
+	builder.add(
+		Mount{Kind: mountBind, Src: "/host/evil", Dest: "/usr", User: true},
+		Mount{Kind: mountBind, Src: "/host/proc", Dest: "/proc/self", User: true},
+		Mount{Kind: mountBind, Src: "/host", Dest: "/", User: true},
+		Mount{Kind: mountBind, Src: "/host/home", Dest: "/home", User: true},
+		Mount{Kind: mountDevBind, Src: "/dev/sdb", Dest: "/dev/sdb", User: true},
+		Mount{Kind: mountDevBind, Src: "/dev", Dest: "/dev", User: true},
+		Mount{Kind: mountBind, Src: "/host/doc", Dest: "/tmp/doc", User: true},
+		Mount{Kind: mountBind, Src: "/host/doc", Dest: "/tmp/doc", User: true},
+	)
+	mounts, errs, err := builder.build()
+	if err != nil {
+		t.Error("Unexpected layout error:", err)
+	}
+	var dests []string
+	for _, mnt := range mounts {
+		dests = append(dests, mnt.Dest)
+	}
+	want := []string{"/tmp", "/usr", "/home/user", "/dev/dri/by-path/card", "/dev/sdb", "/tmp/doc"}
+	if ! slices.Equal(dests, want) {
+		t.Error("Built layout", dests, "want", want)
+	}
+	// The user mounts over /usr, /proc/self, /, /home and /dev
+	if len(errs) != 5 {
+		t.Error("Expected 5 conflicts, got", errs)
+	}
+}
+
+func TestMountConflicts(t *testing.T) {
+	layout := []Mount{
+		bindMount(mountRoBind, "/etc", "/etc"),
+		{Kind: mountRoBind, Phase: mountPhasePrivacy, Src: "/run/fake/machine-id", Dest: "/etc/machine-id"},
+		bindMount(mountRoBind, "/etc/machine-id", "/etc/machine-id"),
+		tryMount(mountRoBind, "/run/systemd/resolve/stub-resolv.conf", "/run/systemd/resolve/stub-resolv.conf"),
+		bindMount(mountRoBind, "/run/systemd/resolve/stub-resolv.conf", "/run/systemd/resolve/stub-resolv.conf"),
+	}
+	for range 2 {
+		var builder mountBuilder
+		builder.add(layout...)
+		mounts, _, err := builder.build()
+		if err != nil {
+			t.Error("Unexpected layout error:", err)
+		}
+		var got []string
+		for _, mnt := range mounts {
+			got = append(got, mnt.String())
+		}
+		want := []string{
+			"--ro-bind /etc /etc",
+			"--ro-bind /run/systemd/resolve/stub-resolv.conf /run/systemd/resolve/stub-resolv.conf",
+			"--ro-bind /run/fake/machine-id /etc/machine-id",
+		}
+		if ! slices.Equal(got, want) {
+			t.Error("Built layout", got, "want", want)
+		}
+		slices.Reverse(layout)
+	}
+
+	var builder mountBuilder
+	builder.add(
+		tmpfsMount("/tmp"),
+		bindMount(mountRoBind, "/dev/null", "/tmp/"),
+	)
+	if _, _, err := builder.build(); err == nil {
+		t.Error("Conflicting built-in mounts accepted")
+	}
+}
+
+func TestMountOrder(t *testing.T) {
+	layout := []Mount{
+		tmpfsMount("/sys"),
+		tmpfsMount("/sys/class/drm"),
+		{Kind: mountSymlink, Phase: mountPhaseDevice, Src: "/sys/devices/card0", Dest: "/sys/class/drm/card0"},
+		{Kind: mountTmpfs, Phase: mountPhaseMask, Dest: "/sys/module/nvidia"},
+		tryMount(mountRoBind, "/sys/module", "/sys/module"),
+		{Kind: mountTmpfs, Phase: mountPhasePrivacy, Dest: "/proc/1"},
+		{Kind: mountBind, Src: "/host/doc", Dest: "/tmp/doc", User: true},
+		{Kind: mountProc, Dest: "/proc"},
+		tmpfsMount("/tmp"),
+	}
+	var hashes []string
+	for range 2 {
+		var builder mountBuilder
+		builder.add(layout...)
+		mounts, errs, err := builder.build()
+		if len(errs) > 0 || err != nil {
+			t.Error("Unexpected conflicts:", errs, err)
+		}
+		var dests []string
+		for _, mnt := range mounts {
+			dests = append(dests, mnt.Dest)
+		}
+		want := []string{"/proc", "/sys", "/tmp", "/sys/module", "/sys/class/drm", "/sys/module/nvidia", "/sys/class/drm/card0", "/tmp/doc", "/proc/1"}
+		if ! slices.Equal(dests, want) {
+			t.Error("Mounts ordered as", dests, "want", want)
+		}
+		hashes = append(hashes, layoutHash(mounts, "123"))
+		slices.Reverse(layout)
+	}
+	if hashes[0] != hashes[1] {
+		t.Error("Layout hash depends on delivery order")
+	}
+
+	flatpak := []Mount{bindMount(mountRoBind, "/run/user/1000/.flatpak/123", "/run/user/1000/.flatpak/123")}
+	other := []Mount{bindMount(mountRoBind, "/run/user/1000/.flatpak/456", "/run/user/1000/.flatpak/456")}
+	if layoutHash(flatpak, "123") != layoutHash(other, "456") {
+		t.Error("Layout hash depends on the instance ID")
+	}
+}
+
+func TestWriteBwrapArgs(t *testing.T) {
+	oldRuntimeDir, oldInstanceID := xdgDir.runtimeDir, runtimeInfo.instanceID
+	t.Cleanup(func() {
+		xdgDir.runtimeDir, runtimeInfo.instanceID = oldRuntimeDir, oldInstanceID
+	})
+	xdgDir.runtimeDir = t.TempDir()
+	runtimeInfo.instanceID = "42"
+	err := os.MkdirAll(filepath.Join(xdgDir.runtimeDir, ".flatpak", "42-private"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--ro-bind", "/home/user/My Files", "/home/user/My Files", "--unshare-pid"}
+	path, err := writeBwrapArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(content), "\x00"), "\x00")
+	if ! slices.Equal(got, args) {
+		t.Error("Read back", got, "want", args)
+	}
+}
+
+func TestDeviceNodes(t *testing.T) {
+	layout := []Mount{
+		{Kind: mountDevBind, Phase: mountPhaseDevice, Src: "/dev/kvm", Dest: "/dev/kvm"},
+		{Kind: mountDevBind, Phase: mountPhaseDevice, Src: "/dev/dri", Dest: "/dev/dri"},
+		{Kind: mountDevBind, Phase: mountPhaseDevice, Src: "/dev/dri", Dest: "/dev/dri"},
+		{Kind: mountSymlink, Phase: mountPhaseDevice, Src: "/sys/devices/card0", Dest: "/dev/dri/by-path/card"},
+		{Kind: mountRoBind, Phase: mountPhaseDevice, Src: "/sys/class/drm", Dest: "/sys/class/drm"},
+		{Kind: mountDev, Dest: "/dev"},
+		bindMount(mountDevBind, "/dev/null", "/dev/null"),
+	}
+	want := []string{"/dev/dri", "/dev/kvm"}
+	if got := deviceNodes(layout); ! slices.Equal(got, want) {
+		t.Error("Device nodes", got, "want", want)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+const netsockSocket string = "/run/netsock/control.sock"
+
+// Destinations covering every address
+var netsockDenyAll = []string{
+	"0.0.0.0/0",
+	"::/0",
+}
+
+// Whether network access was cut off at runtime
+var networkCutOff atomic.Bool
+
+// Serializes changes of networkCutOff and the netsock rules, requests arrive over D-Bus and Varlink
+var networkLock sync.Mutex
+
+func setNetworkLive(config Config, enabled bool) error {
+	if ! config.Network.Enable {
+		return errors.New("Network access is disabled by configuration, a restart is needed")
+	}
+	if _, err := os.Stat(netsockSocket); err != nil {
+		return errors.New("netsock is not available, a restart is needed to change network access")
+	}
+	networkLock.Lock()
+	defer networkLock.Unlock()
+	if networkCutOff.Load() != enabled {
+		pecho("debug", "Network access already in requested state")
+		return nil
+	}
+	jsonObj, err := json.Marshal(genNetsockSig(config, netsockDenyAll))
+	if err != nil {
+		return err
+	}
+	rules := make(chan string, 1)
+	rules <- string(jsonObj)
+	close(rules)
+	if enabled {
+		err = dialNetsock("/remove", rules)
+		if err == nil {
+			// Both rules share the signature of the sandbox, so removal may take the
+			// configured filter along. Denying its destinations twice does no harm
+			err = setFirewall(config)
+		}
+	} else {
+		err = dialNetsock("/add", rules)
+	}
+	if err != nil {
+		return err
+	}
+	networkCutOff.Store(! enabled)
+	if enabled {
+		pecho("info", "Network access restored")
+	} else {
+		pecho("info", "Network access cut off")
+	}
+	return nil
+}
+
+func (m *DBusControlRequest) SetNetwork(enabled bool) (*godbus.Error) {
+	err := setNetworkLive(m.Config, enabled)
+	if err != nil {
+		return godbus.MakeFailedError(err)
+	}
+	if enabled {
+		audit(m.Config, "network", auditGranted, "", "restored at runtime")
+	} else {
+		audit(m.Config, "network", auditRevoked, "", "cut off at runtime")
+	}
+	return nil
+}
+
+func networkState(config Config) string {
+	if ! config.Network.Enable {
+		return "disabled"
+	} else if networkCutOff.Load() {
+		return "cut off"
+	}
+	return "enabled"
+}
+
+// Handles --actions network on|off
+func setNetworkRemote(config Config, state string) {
+	var enabled bool
+	switch state {
+		case "on", "enable":
+			enabled = true
+		case "off", "disable":
+			enabled = false
+		default:
+			pecho("warn", "Unrecognised network state " + state + ", expecting on or off")
+			return
+	}
+	conn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("crit", "Could not connect to session bus:", err)
+		return
+	}
+	busObj := conn.Object(
+		"top.kimiblock.portable." + config.Metadata.AppID,
+		"/top/kimiblock/portable/daemon",
+	)
+	call := busObj.Call("top.kimiblock.Portable.Controller.SetNetwork", 0, enabled)
+	if call.Err != nil {
+		pecho("warn", "Could not change network access: " + call.Err.Error())
+		return
+	}
+	fmt.Println("Network access turned " + state)
+}
@@ -35,7 +35,9 @@ func generatePasswdFile(config Config) {
 	builder.WriteString(":")
 	builder.WriteString(user.Gid)
 	builder.WriteString(":")
-	builder.WriteString(user.Name)
+	if ! config.Privacy.AnonymizeIdentity {
+		builder.WriteString(user.Name)
+	}
 	builder.WriteString(":")
 	builder.WriteString(filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory))
 	builder.WriteString(":" + shell)
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Profile names become an element of the bus name, so they follow its rules
+var profileNameExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+func validProfileName(name string) error {
+	if ! profileNameExp.MatchString(name) {
+		return errors.New("invalid profile name " + name + ": use up to 64 letters, digits and underscores, not starting with a digit")
+	}
+	// Would share the application ID of ephemeral instances
+	if "." + name == ephemeralSuffix {
+		return errors.New("invalid profile name " + name + ": reserved for ephemeral instances")
+	}
+	return nil
+}
+
+// Directory holding the state of named profiles, relative to XDG_DATA_HOME
+func profilesDir(config Config) string {
+	if len(config.profile) > 0 {
+		return filepath.Dir(config.Metadata.StateDirectory)
+	}
+	if len(config.Profiles.Directory) > 0 {
+		return config.Profiles.Directory
+	}
+	return filepath.Join(config.Metadata.StateDirectory, "profiles")
+}
+
+// Scans the command line for --profile. Like --ephemeral, it changes the application ID
+func profileRequested(args []string) (string, bool) {
+	for idx, arg := range args {
+		switch arg {
+			case "--":
+				return "", false
+			case "--profile":
+				if idx + 1 < len(args) {
+					return args[idx + 1], true
+				}
+				return "", true
+		}
+	}
+	return "", false
+}
+
+func applyProfile(config *Config, args []string) {
+	name, ok := profileRequested(args)
+	if ! ok {
+		return
+	}
+	err := validProfileName(name)
+	if err != nil {
+		pecho("crit", "Could not use profile:", err)
+		return
+	}
+	config.Metadata.StateDirectory = filepath.Join(profilesDir(*config), name)
+	config.profile = name
+	config.baseAppID = config.Metadata.AppID
+	config.Metadata.AppID = config.Metadata.AppID + "." + name
+	config.Schedule.Calendar = nil
+}
+
+// Arguments starting the same profile and ephemeral variant of the application again
+func instanceArgs(config Config) []string {
+	var args []string
+	if len(config.baseAppID) == 0 {
+		return args
+	}
+	if len(config.profile) > 0 {
+		args = append(args, "--profile", config.profile)
+	}
+	if config.ephemeralSnapshot {
+		args = append(args, "--ephemeral-snapshot")
+	} else if config.ephemeral {
+		args = append(args, "--ephemeral")
+	}
+	return args
+}
+
+// Hides named profiles from the default profile when they live inside its state directory
+func profilesMask(config Config) []Mount {
+	if len(config.profile) > 0 {
+		return []Mount{}
+	}
+	dir := filepath.Join(xdgDir.dataDir, profilesDir(config))
+	if ! pathWithin(dir, filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory)) {
+		return []Mount{}
+	}
+	return withPhase(maskDir(dir), mountPhasePrivacy)
+}
+
+func listProfiles(config Config) error {
+	entries, err := os.ReadDir(filepath.Join(xdgDir.dataDir, profilesDir(config)))
+	if err != nil && ! os.IsNotExist(err) {
+		return err
+	}
+	busConn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("warn", "Could not connect to session bus:", err)
+	}
+	var builder strings.Builder
+	builder.WriteString("Profiles: \n")
+	for _, entry := range entries {
+		if ! entry.IsDir() || validProfileName(entry.Name()) != nil {
+			continue
+		}
+		state := "stopped"
+		if busConn != nil && daemonRunning(busConn, installedAppID(config) + "." + entry.Name()) {
+			state = "running"
+		}
+		builder.WriteString("	" + entry.Name() + ": " + state + "\n")
+	}
+	fmt.Print(builder.String())
+	return nil
+}
+
+// Removes the state and document portal permissions of a profile
+func deleteProfile(config Config, name string) error {
+	err := validProfileName(name)
+	if err != nil {
+		return err
+	}
+	appID := installedAppID(config) + "." + name
+	busConn, err := godbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	if daemonRunning(busConn, appID) {
+		return errors.New("profile " + name + " is running")
+	}
+	dir := filepath.Join(xdgDir.dataDir, profilesDir(config), name)
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	resetCmd := exec.Command("flatpak", "permission-reset", appID)
+	resetCmd.Stderr = os.Stderr
+	err = resetCmd.Run()
+	if err != nil {
+		pecho("warn", "Could not reset permissions of " + appID + ":", err)
+	}
+	pecho("info", "Deleted profile " + name)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"work":		true,
+		"Personal_2":	true,
+		"2fa":		false,
+		"a.b":		false,
+		"../etc":	false,
+		"Ephemeral":	false,
+		"ephemeral":	true,
+		"":		false,
+	} {
+		if got := validProfileName(name) == nil; got != valid {
+			t.Error("validProfileName(" + name + ") returned", got)
+		}
+	}
+
+	var config Config
+	config.Metadata.AppID = "org.example.Chat"
+	config.Metadata.StateDirectory = "Chat"
+	applyProfile(&config, []string{"--profile", "work", "--ephemeral"})
+	applyEphemeral(&config, []string{"--profile", "work", "--ephemeral"})
+	if config.Metadata.AppID != "org.example.Chat.work.Ephemeral" {
+		t.Error("Unexpected application ID " + config.Metadata.AppID)
+	}
+	if config.Metadata.StateDirectory != "Chat/profiles/work" {
+		t.Error("Unexpected state directory " + config.Metadata.StateDirectory)
+	}
+	if installedAppID(config) != "org.example.Chat" {
+		t.Error("Unexpected installed application ID " + installedAppID(config))
+	}
+	if profilesDir(config) != "Chat/profiles" {
+		t.Error("Unexpected profiles directory " + profilesDir(config))
+	}
+}
+
+func TestProfileLaunch(t *testing.T) {
+	var config Config
+	config.Metadata.AppID = "org.example.Chat"
+	config.Metadata.StateDirectory = "Chat"
+	config.Path = "/usr/lib/portable/info/org.example.Chat/config"
+	applyProfile(&config, []string{"--profile", "work"})
+
+	args := proxyOwnArgs(config)
+	for _, want := range []string{
+		"--own=org.example.Chat.work",
+		"--own=org.example.Chat.work.*",
+		"--own=org.mpris.MediaPlayer2.org.example.Chat.work",
+		"--own=org.mpris.MediaPlayer2.Chat.work",
+	} {
+		if ! slices.Contains(args, want) {
+			t.Error("Missing own rule " + want + " in", args)
+		}
+	}
+	for _, unwanted := range []string{"--own=org.example.Chat", "--own=org.example.Chat.*"} {
+		if slices.Contains(args, unwanted) {
+			t.Error("Profile may own the name of the default instance: " + unwanted)
+		}
+	}
+
+	entry := stubDesktopEntry(config)
+	if ! strings.Contains(entry, "Exec=env _portableConfig=" + config.Path + " portable --profile work\n") {
+		t.Error("Desktop entry does not launch the profile:", entry)
+	}
+	if ! strings.Contains(entry, "NoDisplay=true\n") {
+		t.Error("Desktop entry of the profile is shown in menus:", entry)
+	}
+}
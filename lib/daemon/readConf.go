@@ -154,6 +154,43 @@ func getConf() Config {
 		if ! md.IsDefined("advanced", "flatpakInfo") {
 			config.Advanced.FlatpakInfo = true
 		}
+		if config.Processes.StopTimeout <= 0 {
+			config.Processes.StopTimeout = 10
+		}
+		if ! md.IsDefined("processes", "restartDelay") {
+			config.Processes.RestartDelay = 1
+		}
+		if config.Processes.RestartBurst <= 0 {
+			config.Processes.RestartBurst = 5
+		}
+		if len(config.Processes.Watchdog) == 0 {
+			config.Processes.Watchdog = "unit"
+		}
+		if config.Processes.WatchdogMisses <= 0 {
+			config.Processes.WatchdogMisses = 3
+		}
+		if len(config.Processes.WatchdogAction) == 0 {
+			config.Processes.WatchdogAction = "stop"
+		}
+		if len(config.Privacy.InputMethod) == 0 {
+			config.Privacy.InputMethod = "auto"
+		}
+		if len(config.Privacy.Etc) == 0 {
+			if config.Privacy.Lockdown {
+				config.Privacy.Etc = "minimal"
+			} else {
+				config.Privacy.Etc = "full"
+			}
+		}
+		if ! md.IsDefined("theme", "profiles") {
+			config.Theme.Profiles = themeProfilesDefault
+		}
+		if len(config.Schedule.Mode) == 0 {
+			config.Schedule.Mode = "headless"
+		}
+		if config.Hooks.Timeout <= 0 {
+			config.Hooks.Timeout = 30
+		}
 		if config.System.GameMode {
 			config.System.DeviceAllow = append(
 				config.System.DeviceAllow,
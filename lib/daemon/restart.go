@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// Restarts counted against processes.restartBurst happen within this window
+const restartBurstWindow = time.Minute
+
+// Set once a stop has been requested, suppresses restarts
+var stopRequested atomic.Bool
+
+type restartTracker struct {
+	history		[]time.Time
+}
+
+// Decides whether the application should be started again after exiting
+func (r *restartTracker) shouldRestart(config Config, failed bool) bool {
+	switch config.Processes.Restart {
+		case "always":
+		case "on-failure":
+			if ! failed {
+				return false
+			}
+		default:
+			return false
+	}
+	return r.allowRestart(config)
+}
+
+// Checks whether a restart is possible regardless of the restart policy, and records it
+func (r *restartTracker) allowRestart(config Config) bool {
+	if stopRequested.Load() || config.isDebug {
+		return false
+	}
+	now := time.Now()
+	var recent []time.Time
+	for _, t := range r.history {
+		if now.Sub(t) < restartBurstWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= config.Processes.RestartBurst {
+		pecho(
+			"warn",
+			"Application restarted", len(recent), "times within", restartBurstWindow.String() + ", giving up",
+		)
+		return false
+	}
+	r.history = append(recent, now)
+	return true
+}
+
+// Waits for the restart delay and clears the failed state of the previous run
+func prepareRestart(conn *dbus.Conn, config Config) bool {
+	pecho("info", "Restarting application in", config.Processes.RestartDelay, "seconds")
+	time.Sleep(time.Duration(config.Processes.RestartDelay) * time.Second)
+	if stopRequested.Load() {
+		return false
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 1 * time.Second)
+	defer cancelFunc()
+	err := conn.ResetFailedUnitContext(ctx, appUnitName(config))
+	if err != nil {
+		pecho("debug", "Could not reset failed state of application unit:", err)
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldRestart(t *testing.T) {
+	for _, tc := range []struct {
+		policy		string
+		failed		bool
+		want		bool
+	}{
+		{"no", true, false},
+		{"no", false, false},
+		{"", true, false},
+		{"on-failure", true, true},
+		{"on-failure", false, false},
+		{"always", true, true},
+		{"always", false, true},
+	} {
+		var config Config
+		config.Processes.Restart = tc.policy
+		config.Processes.RestartBurst = 5
+		var r restartTracker
+		if got := r.shouldRestart(config, tc.failed); got != tc.want {
+			t.Error("Policy", tc.policy, "failed", tc.failed, "restarted:", got)
+		}
+	}
+}
+
+func TestRestartBurst(t *testing.T) {
+	var config Config
+	config.Processes.Restart = "always"
+	config.Processes.RestartBurst = 3
+	var r restartTracker
+	for i := range 3 {
+		if ! r.shouldRestart(config, true) {
+			t.Fatal("Restart", i + 1, "refused within the burst")
+		}
+	}
+	if r.shouldRestart(config, true) {
+		t.Fatal("Restart allowed after the burst was exhausted")
+	}
+
+	// Restarts outside of the window no longer count
+	old := time.Now().Add(-2 * restartBurstWindow)
+	r.history = []time.Time{old, old, old}
+	if ! r.shouldRestart(config, true) {
+		t.Fatal("Restarts outside of the window were counted")
+	}
+
+	stopRequested.Store(true)
+	defer stopRequested.Store(false)
+	r.history = nil
+	if r.shouldRestart(config, true) {
+		t.Fatal("Restart allowed after a stop was requested")
+	}
+}
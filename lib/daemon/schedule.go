@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+type timerCalendar struct {
+	Base		string
+	Spec		string
+}
+
+func schedulePrefix(config Config) string {
+	return "portable-schedule-" + config.Metadata.AppID + "-"
+}
+
+// Environment variable pointing Portable to the configuration
+func confEnv(config Config) string {
+	if config.isModern {
+		return "PORTABLE_CONF=" + config.Path
+	}
+	return "_portableConfig=" + config.Path
+}
+
+// Name of a timer created on the command line, unique even within the same second
+func scheduleName(now time.Time) string {
+	return strconv.FormatInt(now.Unix(), 10) + "-" + fmt.Sprintf("%04x", rand.Intn(0x10000))
+}
+
+// Whether the environment lets the application reach a display. Timer services inherit
+// the environment of the user service manager, not the one of the launching shell
+func sessionHasDisplay(env map[string]string) bool {
+	return env["WAYLAND_DISPLAY"] != "" || env["DISPLAY"] != ""
+}
+
+// Command line the timer runs, either activating via D-Bus or headless
+func scheduleCommand(config Config) ([]string, error) {
+	portableBin, err := exec.LookPath("portable")
+	if err != nil {
+		return nil, errors.New("Could not find portable executable: " + err.Error())
+	}
+	args, err := scheduleArgs(config)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{portableBin}, args...), nil
+}
+
+// Arguments of scheduleCommand, timers of a profile launch that profile
+func scheduleArgs(config Config) ([]string, error) {
+	var mode string
+	switch config.Schedule.Mode {
+		case "dbus":
+			if ! config.BusActivation.Enable {
+				return nil, errors.New("bus activation not enabled")
+			}
+			mode = "--dbus-activation"
+		default:
+			mode = "--headless"
+	}
+	return append([]string{mode}, instanceArgs(config)...), nil
+}
+
+// Creates a transient timer launching the application, name is the suffix of the timer unit
+func addSchedule(ctx context.Context, conn *dbus.Conn, config Config, name string, spec string) error {
+	cmd, err := scheduleCommand(config)
+	if err != nil {
+		return err
+	}
+	unit := schedulePrefix(config) + name
+	if managerEnv, err := managerEnvironment(); err != nil {
+		pecho("debug", "Could not read service manager environment:", err)
+	} else if ! sessionHasDisplay(managerEnv) {
+		pecho(
+			"warn",
+			"The user service manager has no WAYLAND_DISPLAY or DISPLAY, scheduled launches can not open windows. " +
+			"Import them with systemctl --user import-environment",
+		)
+	}
+	serviceProps := []dbus.Property{
+		dbus.PropDescription("Scheduled launch of " + config.Metadata.FriendlyName + " (" + config.Metadata.AppID + ")"),
+		dbus.PropType("exec"),
+		dbus.PropExecStart(cmd, true),
+		{
+			Name:	"Environment",
+			Value:	godbus.MakeVariant([]string{confEnv(config)}),
+		},
+	}
+	timerProps := []dbus.Property{
+		dbus.PropDescription("Schedule for " + config.Metadata.FriendlyName + ": " + spec),
+		{
+			Name:	"TimersCalendar",
+			Value:	godbus.MakeVariant([]timerCalendar{{Base: "OnCalendar", Spec: spec}}),
+		},
+		{
+			Name:	"Persistent",
+			Value:	godbus.MakeVariant(true),
+		},
+		{
+			Name:	"RemainAfterElapse",
+			Value:	godbus.MakeVariant(false),
+		},
+	}
+	resChan := make(chan string, 1)
+	_, err = conn.StartTransientUnitAux(
+		ctx,
+		unit + ".timer",
+		"fail",
+		timerProps,
+		[]dbus.PropertyCollection{
+			{
+				Name:		unit + ".service",
+				Properties:	serviceProps,
+			},
+		},
+		resChan,
+	)
+	if err != nil {
+		return err
+	}
+	if res := <- resChan; res != "done" {
+		return errors.New("Could not start " + unit + ".timer: " + res)
+	}
+	return nil
+}
+
+// Creates timers from the schedule section which are not loaded yet,
+// transient timers do not survive the user manager
+func applyConfigSchedule(conn *dbus.Conn, config Config) {
+	if len(config.Schedule.Calendar) == 0 {
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancelFunc()
+	for idx, spec := range config.Schedule.Calendar {
+		name := "config-" + strconv.Itoa(idx)
+		units, err := listUnits(ctx, conn, schedulePrefix(config) + name + ".timer")
+		if err != nil {
+			pecho("warn", "Could not list timers:", err)
+			return
+		}
+		if len(units) > 0 {
+			continue
+		}
+		err = addSchedule(ctx, conn, config, name, spec)
+		if err != nil {
+			pecho("warn", "Could not schedule " + spec + ":", err)
+		} else {
+			pecho("debug", "Scheduled launch at " + spec)
+		}
+	}
+}
+
+func listSchedules(ctx context.Context, conn *dbus.Conn, config Config) error {
+	units, err := listUnits(ctx, conn, schedulePrefix(config) + "*.timer")
+	if err != nil {
+		return err
+	}
+	var builder strings.Builder
+	builder.WriteString("Scheduled launches: \n")
+	for _, unit := range units {
+		name := strings.TrimSuffix(strings.TrimPrefix(unit.Name, schedulePrefix(config)), ".timer")
+		var specs []string
+		if prop, err := conn.GetUnitTypePropertyContext(ctx, unit.Name, "Timer", "TimersCalendar"); err == nil {
+			var calendars []struct {
+				Base	string
+				Spec	string
+				Next	uint64
+			}
+			if godbus.Store([]any{prop.Value.Value()}, &calendars) == nil {
+				for _, cal := range calendars {
+					specs = append(specs, cal.Spec)
+				}
+			}
+		}
+		next := "n/a"
+		if prop, err := conn.GetUnitTypePropertyContext(ctx, unit.Name, "Timer", "NextElapseUSecRealtime"); err == nil {
+			if usec, ok := prop.Value.Value().(uint64); ok && usec > 0 {
+				next = time.UnixMicro(int64(usec)).Format(time.DateTime)
+			}
+		}
+		builder.WriteString("	" + name + ": " + strings.Join(specs, ", ") + ", next: " + next + "\n")
+	}
+	fmt.Print(builder.String())
+	return nil
+}
+
+// Removes a timer by its name shown in list, or all timers of the application
+func removeSchedule(ctx context.Context, conn *dbus.Conn, config Config, name string) error {
+	pattern := schedulePrefix(config) + name + ".timer"
+	if name == "all" {
+		pattern = schedulePrefix(config) + "*.timer"
+	}
+	units, err := listUnits(ctx, conn, pattern)
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return errors.New("no such schedule: " + name)
+	}
+	for _, unit := range units {
+		_, err := conn.StopUnitContext(ctx, unit.Name, "replace", nil)
+		if err != nil {
+			return err
+		}
+		pecho("info", "Removed " + unit.Name)
+	}
+	return nil
+}
+
+// Handles --actions schedule, args are the arguments after it
+func scheduleAction(config Config, args []string) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancelFunc()
+	conn, err := dbus.NewUserConnectionContext(ctx)
+	if err != nil {
+		pecho("warn", "Could not connect to user service manager:", err)
+		return
+	}
+	defer conn.Close()
+	switch {
+		case len(args) == 0:
+			pecho("warn", "--actions schedule requires an OnCalendar specification, list or remove")
+		case args[0] == "list":
+			err = listSchedules(ctx, conn, config)
+		case args[0] == "remove":
+			if len(args) < 2 {
+				err = errors.New("--actions schedule remove requires a name or all")
+			} else {
+				err = removeSchedule(ctx, conn, config, args[1])
+			}
+		default:
+			name := scheduleName(time.Now())
+			err = addSchedule(ctx, conn, config, name, args[0])
+			if err == nil {
+				pecho("info", "Scheduled launch at " + args[0] + " as " + name)
+			}
+	}
+	if err != nil {
+		pecho("warn", "Could not manage schedule:", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestScheduleName(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	seen := map[string]bool{}
+	for range 16 {
+		seen[scheduleName(now)] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Names within the same second collide:", seen)
+	}
+	for name := range seen {
+		if len(name) != len("1700000000-0000") || name[:11] != "1700000000-" {
+			t.Error("Unexpected name", name)
+		}
+	}
+}
+
+func TestSessionHasDisplay(t *testing.T) {
+	for _, tc := range []struct {
+		env		map[string]string
+		want		bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"DISPLAY": ""}, false},
+		{map[string]string{"DISPLAY": ":0"}, true},
+		{map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, true},
+	} {
+		if got := sessionHasDisplay(tc.env); got != tc.want {
+			t.Error("Environment", tc.env, "has display:", got)
+		}
+	}
+}
+
+func TestScheduleArgs(t *testing.T) {
+	var config Config
+	config.Metadata.AppID = "org.example.Chat"
+	config.Metadata.StateDirectory = "Chat"
+	if args, err := scheduleArgs(config); err != nil || ! slices.Equal(args, []string{"--headless"}) {
+		t.Error("Default instance scheduled as", args, err)
+	}
+
+	applyProfile(&config, []string{"--profile", "work"})
+	want := []string{"--headless", "--profile", "work"}
+	if args, err := scheduleArgs(config); err != nil || ! slices.Equal(args, want) {
+		t.Error("Profile scheduled as", args, err, "want", want)
+	}
+
+	config.Schedule.Mode = "dbus"
+	if _, err := scheduleArgs(config); err == nil {
+		t.Error("Scheduled bus activation while it is disabled")
+	}
+	config.BusActivation.Enable = true
+	applyEphemeral(&config, []string{"--ephemeral"})
+	want = []string{"--dbus-activation", "--profile", "work", "--ephemeral"}
+	if args, err := scheduleArgs(config); err != nil || ! slices.Equal(args, want) {
+		t.Error("Ephemeral profile scheduled as", args, err, "want", want)
+	}
+}
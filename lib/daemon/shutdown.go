@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Built-in shutdown stages, in the order they are executed
+const (
+	stageAppStop		= "app-stop"
+	stageAppWait		= "app-wait"
+	stageAppKill		= "app-kill"
+	stageConsole		= "console-restore"
+	stageProxyStop		= "proxy-stop"
+	stageDocuments		= "document-revoke"
+	stagePostStop		= "post-stop-hooks"
+	stageRuntimeDir		= "runtime-dir"
+	stageBusName		= "bus-name"
+)
+
+type stopStage struct {
+	Name		string
+	// Stages that must finish before this one starts
+	After		[]string
+	hooks		[]func()
+}
+
+// Holds named cleanup stages, hooks of a stage run concurrently once its dependencies are done
+type stopRegistry struct {
+	lock		sync.Mutex
+	stages		map[string]*stopStage
+	started		bool
+	once		sync.Once
+	done		chan struct{}
+}
+
+var stopStages = newStopRegistry()
+
+func newStopRegistry() *stopRegistry {
+	r := &stopRegistry{
+		stages:		map[string]*stopStage{},
+		done:		make(chan struct{}),
+	}
+	r.define(stageAppStop)
+	r.define(stageAppWait, stageAppStop)
+	r.define(stageAppKill, stageAppWait)
+	r.define(stageConsole, stageAppKill)
+	r.define(stageProxyStop, stageAppKill)
+	r.define(stageDocuments, stageAppKill)
+	r.define(stagePostStop, stageAppKill, stageProxyStop, stageDocuments)
+	r.define(stageRuntimeDir, stageAppKill, stageProxyStop, stagePostStop)
+	r.define(stageBusName, stageConsole, stageDocuments, stageRuntimeDir)
+	return r
+}
+
+func (r *stopRegistry) define(name string, after ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.stages[name]; ok {
+		return
+	}
+	r.stages[name] = &stopStage{
+		Name:		name,
+		After:		after,
+	}
+}
+
+// Registers a function to be executed in the named stage when the sandbox stops
+func addStopHook(stage string, hook func()) {
+	stopStages.add(stage, hook)
+}
+
+func (r *stopRegistry) add(stage string, hook func()) {
+	r.lock.Lock()
+	if r.started {
+		r.lock.Unlock()
+		pecho("warn", "Shutdown already started, running hook of stage " + stage + " now")
+		hook()
+		return
+	}
+	defer r.lock.Unlock()
+	st, ok := r.stages[stage]
+	if ! ok {
+		pecho("warn", "Unknown shutdown stage " + stage + ", running hook last")
+		st = r.stages[stageBusName]
+	}
+	st.hooks = append(st.hooks, hook)
+}
+
+// Executes all stages in dependency order, calling it again waits for the first run
+func (r *stopRegistry) run() {
+	r.once.Do(func() {
+		defer close(r.done)
+		timeStart := time.Now()
+		r.lock.Lock()
+		r.started = true
+		doneChans := make(map[string]chan struct{}, len(r.stages))
+		for name := range r.stages {
+			doneChans[name] = make(chan struct{})
+		}
+		var wg sync.WaitGroup
+		for name, stage := range r.stages {
+			hooks := append([]func(){}, stage.hooks...)
+			after := stage.After
+			wg.Go(func() {
+				defer close(doneChans[name])
+				for _, dep := range after {
+					if ch, ok := doneChans[dep]; ok {
+						<- ch
+					}
+				}
+				stageStart := time.Now()
+				var hookWg sync.WaitGroup
+				for _, hook := range hooks {
+					hookWg.Go(hook)
+				}
+				hookWg.Wait()
+				pecho(
+					"debug",
+					"Shutdown stage " + name + " finished in " + time.Since(stageStart).String(),
+				)
+			})
+		}
+		r.lock.Unlock()
+		wg.Wait()
+		pecho("debug", "Shutdown finished in " + time.Since(timeStart).String())
+	})
+	<- r.done
+}
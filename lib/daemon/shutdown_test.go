@@ -0,0 +1,59 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestStopRegistryOrder(t *testing.T) {
+	r := newStopRegistry()
+	var lock sync.Mutex
+	var order []string
+	for _, stage := range []string{
+		stageBusName,
+		stageRuntimeDir,
+		stageProxyStop,
+		stageAppKill,
+		stageAppWait,
+		stageAppStop,
+	} {
+		r.add(stage, func() {
+			lock.Lock()
+			order = append(order, stage)
+			lock.Unlock()
+		})
+	}
+
+	// Concurrent stop requests must only run the stages once
+	var wg sync.WaitGroup
+	wg.Go(r.run)
+	wg.Go(r.run)
+	wg.Wait()
+
+	if len(order) != 6 {
+		t.Fatal("Expected every stage to run exactly once, got:", order)
+	}
+	before := func(a string, b string) {
+		if slices.Index(order, a) > slices.Index(order, b) {
+			t.Fatal("Stage", a, "ran after", b, ":", order)
+		}
+	}
+	before(stageAppStop, stageAppWait)
+	before(stageAppWait, stageAppKill)
+	before(stageAppKill, stageProxyStop)
+	before(stageProxyStop, stageRuntimeDir)
+	before(stageRuntimeDir, stageBusName)
+}
+
+func TestStopRegistryLateHook(t *testing.T) {
+	r := newStopRegistry()
+	r.run()
+	var ran bool
+	r.add(stageRuntimeDir, func() {
+		ran = true
+	})
+	if ! ran {
+		t.Fatal("Hook added after shutdown was dropped")
+	}
+}
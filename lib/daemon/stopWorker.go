@@ -2,32 +2,104 @@ package main
 
 import (
 	"github.com/coreos/go-systemd/v22/dbus"
-	"sync"
 	godbus "github.com/godbus/dbus/v5"
 	"context"
 	"os"
+	"syscall"
+	"time"
 )
 
+func appUnitName(config Config) string {
+	return "app-portable-" + config.Metadata.AppID + "-" + runtimeInfo.instanceID + ".service"
+}
+
+func proxyUnitName(config Config) string {
+	return config.Metadata.FriendlyName + "-" + runtimeInfo.instanceID + "-dbus.service"
+}
+
+// Whether a unit is still running, units not loaded are treated as stopped
+func unitActive(ctx context.Context, conn *dbus.Conn, unit string) bool {
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "ActiveState")
+	if err != nil {
+		pecho("debug", "Could not query state of " + unit + ":", err)
+		return false
+	}
+	switch state := parseStr(prop.Value.Value()); state {
+		case "inactive", "failed", "":
+			return false
+		default:
+			return true
+	}
+}
+
+// Registers hooks that tear down systemd units of the sandbox
+func registerUnitStages(conn *dbus.Conn, sdContext context.Context, config Config) {
+	appUnit := appUnitName(config)
+	proxyUnit := proxyUnitName(config)
+	addStopHook(stageAppStop, func() {
+		if ! unitActive(sdContext, conn, appUnit) {
+			pecho("debug", "Application unit already stopped")
+			return
+		}
+		_, err := conn.StopUnitContext(sdContext, appUnit, "replace", nil)
+		if err != nil {
+			pecho("debug", "User manager returned error: " + err.Error())
+		}
+	})
+	addStopHook(stageAppWait, func() {
+		deadline := time.Now().Add(time.Duration(config.Processes.StopTimeout) * time.Second)
+		for unitActive(sdContext, conn, appUnit) {
+			if time.Now().After(deadline) {
+				pecho("warn", "Application did not exit within", config.Processes.StopTimeout, "seconds")
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+	addStopHook(stageAppKill, func() {
+		if ! unitActive(sdContext, conn, appUnit) {
+			return
+		}
+		pecho("warn", "Killing application")
+		err := conn.KillUnitWithTarget(sdContext, appUnit, dbus.All, int32(syscall.SIGKILL))
+		if err != nil {
+			pecho("warn", "User manager returned error: " + err.Error())
+		}
+	})
+	addStopHook(stageProxyStop, func() {
+		resChan := make(chan string, 1)
+		_, err := conn.StopUnitContext(sdContext, proxyUnit, "replace", resChan)
+		if err != nil {
+			pecho("warn", "User manager returned error: " + err.Error())
+			return
+		}
+		select {
+			case res := <- resChan:
+				if res != "done" {
+					pecho("warn", "Could not stop D-Bus proxy: " + res)
+				}
+			case <- time.After(5 * time.Second):
+				pecho("warn", "Timed out waiting for D-Bus proxy to stop")
+		}
+	})
+}
+
 // Sending integers to stopSignal will cause the whole program to exit with such code
 func stopAppWorker(conn *dbus.Conn, sdCancelFunc func(), sdContext context.Context, busconn *godbus.Conn, stopSignal chan int, config Config) {
 	sig := <- stopSignal
+	stopRequested.Store(true)
+	setLogField("PHASE", "shutdown")
 	pecho("debug", "Received a quit request from channel")
-	var wg sync.WaitGroup
+	go func () {
+		for range stopSignal {
+			pecho("debug", "Already stopping, ignoring quit request")
+		}
+	} ()
 
-	for {
-		select {
-			case sig := <- stopFuncChan:
-				sigFunc := sig
-				wg.Go(sigFunc)
-				continue
-			default:
-				pecho("debug", "Successfully drained stopFuncChan")
-		}
-		break
-	}
-	wg.Go(func() {
-		doCleanUnit(conn, sdCancelFunc, sdContext, config)
-	})
-	wg.Wait()
+	registerUnitStages(conn, sdContext, config)
+	registerHookStage(config, sig)
+	stopStages.run()
+	conn.Close()
+	sdCancelFunc()
 	os.Exit(sig)
-}
\ No newline at end of file
+}
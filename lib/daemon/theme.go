@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Built-in theme profiles, paths are relative to XDG_CONFIG_HOME or XDG_DATA_HOME
+type themeProfile struct {
+	ConfPaths	[]string
+	DataPaths	[]string
+}
+
+var themeProfiles = map[string]themeProfile{
+	"gtk": {
+		ConfPaths:	[]string{
+			"gtk-3.0/gtk.css",
+			"gtk-3.0/noctalia.css",
+			"gtk-3.0/colors.css",
+			"gtk-3.0/settings.ini",
+			"gtk-4.0/gtk.css",
+			"gtk-4.0/settings.ini",
+		},
+	},
+	"qt": {
+		ConfPaths:	[]string{
+			"kdeglobals",
+			"qt5ct",
+			"qt6ct",
+			"Kvantum",
+		},
+	},
+	"cursor": {
+		ConfPaths:	[]string{"kcminputrc"},
+		DataPaths:	[]string{"icons"},
+	},
+	"icons": {
+		DataPaths:	[]string{"icons"},
+	},
+	"fonts": {
+		ConfPaths:	[]string{"fontconfig"},
+		DataPaths:	[]string{"fonts"},
+	},
+}
+
+var themeProfilesDefault = []string{"gtk", "qt", "cursor", "icons", "fonts"}
+
+// Appearance of the desktop as reported by org.freedesktop.portal.Settings
+type themeSettings struct {
+	// 0 for no preference, 1 for dark and 2 for light
+	ColorScheme	uint32
+	// Accent colour in sRGB, only valid if HasAccent
+	Accent		[3]float64
+	HasAccent	bool
+	GtkTheme	string
+	CursorTheme	string
+	CursorSize	int32
+}
+
+var (
+	themeOnce	sync.Once
+	themeCache	themeSettings
+)
+
+func themeEnabled(config Config, profile string) bool {
+	for _, name := range config.Theme.Profiles {
+		if name == profile {
+			return true
+		}
+	}
+	return false
+}
+
+func readPortalSetting(obj godbus.BusObject, namespace string, key string) (godbus.Variant, error) {
+	var value godbus.Variant
+	err := obj.Call(
+		"org.freedesktop.portal.Settings.ReadOne",
+		0,
+		namespace,
+		key,
+	).Store(&value)
+	if err == nil {
+		return value, nil
+	}
+	// ReadOne was introduced in version 2, Read wraps the value in another variant
+	err = obj.Call(
+		"org.freedesktop.portal.Settings.Read",
+		0,
+		namespace,
+		key,
+	).Store(&value)
+	if err != nil {
+		return value, err
+	}
+	if inner, ok := value.Value().(godbus.Variant); ok {
+		return inner, nil
+	}
+	return value, nil
+}
+
+// Reads the cursor of KDE Plasma, which does not publish it via the portal
+func kdeCursor() (theme string, size int32) {
+	content, err := os.ReadFile(filepath.Join(xdgDir.confDir, "kcminputrc"))
+	if err != nil {
+		return
+	}
+	var inMouse bool
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inMouse = line == "[Mouse]"
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if ! inMouse || ! ok {
+			continue
+		}
+		switch key {
+			case "cursorTheme":
+				theme = val
+			case "cursorSize":
+				if num, err := strconv.ParseInt(val, 10, 32); err == nil {
+					size = int32(num)
+				}
+		}
+	}
+	return
+}
+
+func readThemeSettings() themeSettings {
+	themeOnce.Do(func() {
+		conn, err := godbus.SessionBus()
+		if err != nil {
+			pecho("warn", "Could not read desktop appearance:", err)
+			return
+		}
+		obj := conn.Object("org.freedesktop.portal.Desktop", "/org/freedesktop/portal/desktop")
+		if val, err := readPortalSetting(obj, "org.freedesktop.appearance", "color-scheme"); err == nil {
+			themeCache.ColorScheme, _ = val.Value().(uint32)
+		} else {
+			pecho("debug", "Could not read color scheme:", err)
+		}
+		if val, err := readPortalSetting(obj, "org.freedesktop.appearance", "accent-color"); err == nil {
+			if rgb, ok := val.Value().([]any); ok && len(rgb) == 3 {
+				themeCache.HasAccent = true
+				for idx, channel := range rgb {
+					themeCache.Accent[idx], _ = channel.(float64)
+				}
+			}
+		}
+		if val, err := readPortalSetting(obj, "org.gnome.desktop.interface", "gtk-theme"); err == nil {
+			themeCache.GtkTheme, _ = val.Value().(string)
+		}
+		if val, err := readPortalSetting(obj, "org.gnome.desktop.interface", "cursor-theme"); err == nil {
+			themeCache.CursorTheme, _ = val.Value().(string)
+		}
+		if val, err := readPortalSetting(obj, "org.gnome.desktop.interface", "cursor-size"); err == nil {
+			themeCache.CursorSize, _ = val.Value().(int32)
+		}
+		if len(themeCache.CursorTheme) == 0 {
+			themeCache.CursorTheme, themeCache.CursorSize = kdeCursor()
+		}
+	})
+	return themeCache
+}
+
+// GTK_THEME matching the colour scheme, empty if there is nothing to change
+func gtkThemeEnv(settings themeSettings) string {
+	theme := settings.GtkTheme
+	if settings.ColorScheme != 1 {
+		return theme
+	}
+	if len(theme) == 0 {
+		theme = "Adwaita"
+	}
+	if strings.Contains(strings.ToLower(theme), "dark") {
+		return theme
+	}
+	return theme + ":dark"
+}
+
+func themeEnvs(config Config) {
+	if ! themeEnabled(config, "gtk") && ! themeEnabled(config, "cursor") {
+		return
+	}
+	settings := readThemeSettings()
+	if themeEnabled(config, "cursor") && len(os.Getenv("XCURSOR_THEME")) == 0 {
+		if len(settings.CursorTheme) > 0 {
+			addEnv("XCURSOR_THEME=" + settings.CursorTheme)
+		}
+		if settings.CursorSize > 0 && len(os.Getenv("XCURSOR_SIZE")) == 0 {
+			addEnv("XCURSOR_SIZE=" + strconv.Itoa(int(settings.CursorSize)))
+		}
+	}
+	if themeEnabled(config, "gtk") && len(os.Getenv("GTK_THEME")) == 0 {
+		if theme := gtkThemeEnv(settings); len(theme) > 0 {
+			addEnv("GTK_THEME=" + theme)
+		}
+	}
+}
+
+// Style sheet carrying the accent colour, for GTK 3 themes using the libadwaita colour names
+func accentStyleSheet(settings themeSettings) string {
+	var channels [3]int
+	for idx, val := range settings.Accent {
+		channels[idx] = int(max(0, min(1, val)) * 255 + 0.5)
+	}
+	color := fmt.Sprintf("#%02x%02x%02x", channels[0], channels[1], channels[2])
+	return "@define-color accent_color " + color + ";\n" +
+		"@define-color accent_bg_color " + color + ";\n"
+}
+
+// Binds a generated gtk.css with the accent colour, unless the user has their own
+func accentBind(config Config) []Mount {
+	userCSS := filepath.Join(xdgDir.confDir, "gtk-3.0", "gtk.css")
+	if _, err := os.Stat(userCSS); err == nil {
+		return []Mount{}
+	}
+	settings := readThemeSettings()
+	if ! settings.HasAccent {
+		return []Mount{}
+	}
+	generated := filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID, "gtk-accent.css")
+	err := os.MkdirAll(filepath.Dir(generated), 0700)
+	if err == nil {
+		err = os.WriteFile(generated, []byte(accentStyleSheet(settings)), 0600)
+	}
+	if err != nil {
+		pecho("warn", "Could not write accent colour:", err)
+		return []Mount{}
+	}
+	return []Mount{bindMount(mountRoBind, generated, translatePath(userCSS, config))}
+}
+
+// Read-only binds of the enabled theme profiles and extra paths
+func themeBinds(config Config) []Mount {
+	var mounts []Mount
+	var accent []Mount
+	if themeEnabled(config, "gtk") {
+		accent = accentBind(config)
+	}
+	bind := func(path string) {
+		// The generated style sheet takes the place of gtk.css
+		if len(accent) > 0 && translatePath(path, config) == accent[0].Dest {
+			return
+		}
+		mounts = append(mounts, tryMount(mountRoBind, path, translatePath(path, config)))
+	}
+	for _, name := range config.Theme.Profiles {
+		profile, ok := themeProfiles[name]
+		if ! ok {
+			pecho("warn", "Unknown theme profile " + name)
+			continue
+		}
+		for _, path := range profile.ConfPaths {
+			bind(filepath.Join(xdgDir.confDir, path))
+		}
+		for _, path := range profile.DataPaths {
+			bind(filepath.Join(xdgDir.dataDir, path))
+		}
+	}
+	if themeEnabled(config, "cursor") {
+		bind(filepath.Join(xdgDir.home, ".icons"))
+	}
+	for _, path := range config.Theme.Paths {
+		if ! filepath.IsAbs(path) {
+			path = filepath.Join(xdgDir.home, path)
+		}
+		bind(filepath.Clean(path))
+	}
+	return append(mounts, accent...)
+}
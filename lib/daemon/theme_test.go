@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestGtkThemeEnv(t *testing.T) {
+	for _, tc := range []struct {
+		settings	themeSettings
+		want		string
+	}{
+		{themeSettings{}, ""},
+		{themeSettings{ColorScheme: 1}, "Adwaita:dark"},
+		{themeSettings{ColorScheme: 2, GtkTheme: "Breeze"}, "Breeze"},
+		{themeSettings{ColorScheme: 1, GtkTheme: "Breeze"}, "Breeze:dark"},
+		{themeSettings{ColorScheme: 1, GtkTheme: "Breeze-Dark"}, "Breeze-Dark"},
+	} {
+		if got := gtkThemeEnv(tc.settings); got != tc.want {
+			t.Error("gtkThemeEnv returned " + got + ", want " + tc.want)
+		}
+	}
+}
+
+func TestThemeBindsDedupe(t *testing.T) {
+	oldHome, oldDataDir, oldConfDir := xdgDir.home, xdgDir.dataDir, xdgDir.confDir
+	t.Cleanup(func() {
+		xdgDir.home, xdgDir.dataDir, xdgDir.confDir = oldHome, oldDataDir, oldConfDir
+	})
+	xdgDir.home = "/home/test"
+	xdgDir.dataDir = "/home/test/.local/share"
+	xdgDir.confDir = "/home/test/.config"
+	var config Config
+	config.Metadata.StateDirectory = "Test"
+	config.Theme.Profiles = []string{"cursor", "icons"}
+	config.Theme.Paths = []string{".themes"}
+	var builder mountBuilder
+	builder.add(themeBinds(config)...)
+	mounts, errs, err := builder.build()
+	if len(errs) > 0 || err != nil {
+		t.Error("Theme mounts conflict:", errs, err)
+	}
+	var icons int
+	var extra bool
+	for _, mnt := range mounts {
+		if mnt.Src == "/home/test/.themes" {
+			extra = true
+		}
+		if mnt.Src == "/home/test/.local/share/icons" {
+			icons++
+			if mnt.Dest != "/home/test/.local/share/Test/.local/share/icons" {
+				t.Error("Icons bound at " + mnt.Dest)
+			}
+		}
+	}
+	if icons != 1 {
+		t.Error("Icons bound", icons, "times")
+	}
+	if ! extra {
+		t.Error("Extra path not bound:", mounts)
+	}
+}
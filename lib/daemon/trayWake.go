@@ -19,7 +19,6 @@ import (
 
 func trayWakeNG(config Config, conn *godbus.Conn) error {
 	pecho("debug", "Attempting tray wakeup")
-	const cgMnt string = "/sys/fs/cgroup"
 
 	busObj := conn.Object(
 		"top.kimiblock.portable." + config.Metadata.AppID,
@@ -60,12 +59,42 @@ func trayWakeNG(config Config, conn *godbus.Conn) error {
 		return err
 	}
 	sdConn.Close()
+	pids, err := cgroupPids(ret)
+	if err != nil {
+		return err
+	}
+	names, err := trayItemsOf(conn, pids)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Go(func() {
+			pecho("debug", "Calling Activate on Tray...")
+			tray := conn.Object(name, "/StatusNotifierItem")
+			call := tray.Call("org.kde.StatusNotifierItem.Activate", 0, int32(1), int32(18))
+			if call.Err != nil {
+				pecho("warn", "Could not call for tray wakeup: " + call.Err.Error())
+				fmt.Println(call.Err)
+				return
+			}
+		})
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// Lists PIDs in the control group of a unit, from its properties
+func cgroupPids(props map[string]any) ([]string, error) {
+	const cgMnt string = "/sys/fs/cgroup"
 	var cgPath string
-	cgroupPath, ok := ret["ControlGroup"]
+	cgroupPath, ok := props["ControlGroup"]
 	if ok {
 		cgPath = parseStr(cgroupPath)
 	} else {
-		return errors.New("Could not obtain bus control group: reply invalid")
+		return nil, errors.New("Could not obtain bus control group: reply invalid")
 	}
 	pecho("debug", "Obtained Bus control group: " + cgPath)
 
@@ -75,7 +104,7 @@ func trayWakeNG(config Config, conn *godbus.Conn) error {
 		0700,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer pidsFile.Close()
 
@@ -88,21 +117,28 @@ func trayWakeNG(config Config, conn *godbus.Conn) error {
 		}
 		pids = append(pids, line)
 	}
+	return pids, nil
+}
+
+// Lists bus names of registered tray items owned by any of pids
+func trayItemsOf(conn *godbus.Conn, pids []string) ([]string, error) {
 	var registeredNotifs []string
 	trayObj := conn.Object("org.kde.StatusNotifierWatcher", "/StatusNotifierWatcher")
-	call = trayObj.Call("org.freedesktop.DBus.Properties.Get", 0, "org.kde.StatusNotifierWatcher", "RegisteredStatusNotifierItems")
+	call := trayObj.Call("org.freedesktop.DBus.Properties.Get", 0, "org.kde.StatusNotifierWatcher", "RegisteredStatusNotifierItems")
 	if call.Err != nil {
-		return call.Err
+		return nil, call.Err
 	}
-	err = call.Store(&registeredNotifs)
+	err := call.Store(&registeredNotifs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(registeredNotifs) == 0 {
-		return errors.New("No registered tray icon")
+		return nil, errors.New("No registered tray icon")
 	}
 	busObjUID := conn.Object("org.freedesktop.DBus", "/org/freedesktop/DBus")
 	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var names []string
 	for _, notif := range registeredNotifs {
 		wg.Go(func() {
 			//var newStyle bool
@@ -129,23 +165,13 @@ func trayWakeNG(config Config, conn *godbus.Conn) error {
 				pecho("warn", "Could not get peer PID: " + err.Error())
 				return
 			}
-			pidStr := strconv.Itoa(pid)
-			if slices.Contains(pids, pidStr) {
-				pecho("debug", "Calling Activate on Tray...")
-				tray := conn.Object(name, "/StatusNotifierItem")
-				call := tray.Call("org.kde.StatusNotifierItem.Activate", 0, int32(1), int32(18))
-				if call.Err != nil {
-					pecho("warn", "Could not call for tray wakeup: " + call.Err.Error())
-					fmt.Println(call.Err)
-					return
-				}
-			} else {
-				return
+			if slices.Contains(pids, strconv.Itoa(pid)) {
+				lock.Lock()
+				names = append(names, name)
+				lock.Unlock()
 			}
 		})
 	}
-
 	wg.Wait()
-
-	return nil
+	return names, nil
 }
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+)
+
+const varlinkInterface string = "top.kimiblock.portable.Control"
+
+const varlinkDescription string = `# Controls a running Portable sandbox, mirrors the D-Bus Controller and Info interfaces
+interface top.kimiblock.portable.Control
+
+# Stops the sandbox
+method Stop() -> ()
+
+# Returns human readable runtime information
+method GetInfo() -> (info: []string)
+
+# Returns disk usage of the state directory in MiB, alongside runtime information
+method GetStats() -> (diskUsage: float, info: []string)
+
+# Starts an auxiliary process inside the sandbox, its console is not streamed
+method Exec(arguments: []string) -> ()
+
+# Asks the user to share files or directories with the sandbox
+method Share(directory: bool) -> ()
+
+# Cuts off or restores network access, requires netsock
+method SetNetwork(enabled: bool) -> ()
+
+# Exposes a host path into the sandbox after user consent
+method Expose(host: string, dest: string, readOnly: bool) -> (sandboxPath: string)
+
+# The requested operation could not be completed
+error Failed(reason: string)
+`
+
+const varlinkServiceDescription string = `# The Varlink Service Interface is provided by every varlink service
+interface org.varlink.service
+
+method GetInfo() -> (
+  vendor: string,
+  product: string,
+  version: string,
+  url: string,
+  interfaces: []string
+)
+
+method GetInterfaceDescription(interface: string) -> (description: string)
+
+error InterfaceNotFound (interface: string)
+error MethodNotFound (method: string)
+error MethodNotImplemented (method: string)
+error InvalidParameter (parameter: string)
+`
+
+type varlinkCall struct {
+	Method		string			`json:"method"`
+	Parameters	json.RawMessage		`json:"parameters,omitempty"`
+	Oneway		bool			`json:"oneway,omitempty"`
+	More		bool			`json:"more,omitempty"`
+}
+
+type varlinkReply struct {
+	Parameters	any			`json:"parameters,omitempty"`
+	Error		string			`json:"error,omitempty"`
+}
+
+type varlinkError struct {
+	Name		string
+	Parameters	map[string]any
+}
+
+func (e *varlinkError) Error() string {
+	return e.Name
+}
+
+func varlinkInvalid(parameter string) *varlinkError {
+	return &varlinkError{
+		Name:		"org.varlink.service.InvalidParameter",
+		Parameters:	map[string]any{"parameter": parameter},
+	}
+}
+
+// Decodes call parameters, reporting the named parameter as invalid on failure
+func varlinkParams(params json.RawMessage, req any, parameter string) *varlinkError {
+	if err := json.Unmarshal(params, req); err != nil {
+		return varlinkInvalid(parameter)
+	}
+	return nil
+}
+
+func varlinkFailed(err error) *varlinkError {
+	return &varlinkError{
+		Name:		varlinkInterface + ".Failed",
+		Parameters:	map[string]any{"reason": err.Error()},
+	}
+}
+
+type varlinkMethod func(params json.RawMessage) (any, *varlinkError)
+
+type varlinkService struct {
+	// Interfaces maps interface names to their descriptions
+	Interfaces	map[string]string
+	// Methods maps fully qualified method names to their handlers
+	Methods		map[string]varlinkMethod
+	// Accept decides whether a peer may talk to the service, nil accepts everyone
+	Accept		func(conn *net.UnixConn) bool
+}
+
+func (s *varlinkService) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			pecho("warn", "Could not accept Varlink connection:", err)
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *varlinkService) handleConn(conn net.Conn) {
+	defer conn.Close()
+	if unixConn, ok := conn.(*net.UnixConn); ok && s.Accept != nil {
+		if ! s.Accept(unixConn) {
+			pecho("warn", "Rejected Varlink peer")
+			return
+		}
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		raw, err := reader.ReadBytes(0)
+		if err != nil {
+			if err != io.EOF {
+				pecho("debug", "Varlink connection closed:", err)
+			}
+			return
+		}
+		var call varlinkCall
+		err = json.Unmarshal(raw[:len(raw) - 1], &call)
+		if err != nil {
+			pecho("warn", "Could not decode Varlink call:", err)
+			return
+		}
+		reply := s.dispatch(call)
+		if call.Oneway {
+			continue
+		}
+		encoded, err := json.Marshal(reply)
+		if err != nil {
+			pecho("warn", "Could not encode Varlink reply:", err)
+			return
+		}
+		_, err = conn.Write(append(encoded, 0))
+		if err != nil {
+			pecho("debug", "Could not write Varlink reply:", err)
+			return
+		}
+	}
+}
+
+func (s *varlinkService) dispatch(call varlinkCall) varlinkReply {
+	var params any
+	var vErr *varlinkError
+	// None of the methods stream replies
+	if call.More {
+		return varlinkReply{
+			Error:		"org.varlink.service.InvalidParameter",
+			Parameters:	map[string]any{"parameter": "more"},
+		}
+	}
+	switch call.Method {
+		case "org.varlink.service.GetInfo":
+			interfaces := []string{"org.varlink.service"}
+			for name := range s.Interfaces {
+				interfaces = append(interfaces, name)
+			}
+			params = map[string]any{
+				"vendor":	"Kraftland",
+				"product":	"Portable",
+				"version":	strconv.FormatFloat(float64(version), 'f', 0, 64),
+				"url":		"https://github.com/Kraftland/portable",
+				"interfaces":	interfaces,
+			}
+		case "org.varlink.service.GetInterfaceDescription":
+			var req struct {
+				Interface	string	`json:"interface"`
+			}
+			vErr = varlinkParams(call.Parameters, &req, "interface")
+			if vErr != nil {
+				break
+			}
+			desc, ok := s.Interfaces[req.Interface]
+			if req.Interface == "org.varlink.service" {
+				desc, ok = varlinkServiceDescription, true
+			}
+			if ! ok {
+				vErr = &varlinkError{
+					Name:		"org.varlink.service.InterfaceNotFound",
+					Parameters:	map[string]any{"interface": req.Interface},
+				}
+				break
+			}
+			params = map[string]any{"description": desc}
+		default:
+			method, ok := s.Methods[call.Method]
+			if ! ok {
+				idx := strings.LastIndex(call.Method, ".")
+				_, known := s.Interfaces[call.Method[:max(idx, 0)]]
+				if ! known {
+					vErr = &varlinkError{
+						Name:		"org.varlink.service.InterfaceNotFound",
+						Parameters:	map[string]any{"interface": call.Method[:max(idx, 0)]},
+					}
+				} else {
+					vErr = &varlinkError{
+						Name:		"org.varlink.service.MethodNotFound",
+						Parameters:	map[string]any{"method": call.Method},
+					}
+				}
+				break
+			}
+			params, vErr = method(call.Parameters)
+	}
+	if vErr != nil {
+		return varlinkReply{
+			Error:		vErr.Name,
+			Parameters:	vErr.Parameters,
+		}
+	}
+	if params == nil {
+		params = struct{}{}
+	}
+	return varlinkReply{Parameters: params}
+}
+
+// Control group of a process, from the unified hierarchy entry of /proc/<pid>/cgroup
+func procCgroup(content string) (string, error) {
+	for line := range strings.SplitSeq(content, "\n") {
+		path, ok := strings.CutPrefix(line, "0::")
+		if ok && len(path) > 0 {
+			return path, nil
+		}
+	}
+	return "", errors.New("no unified control group entry")
+}
+
+func varlinkSocketPath(config Config) string {
+	return filepath.Join(xdgDir.runtimeDir, "portable", config.Metadata.AppID + ".control")
+}
+
+// Rejects peers of other users and peers inside the sandbox's control group. The socket
+// is not bound into the sandbox, this guards against it being passed in
+func varlinkAcceptHost(config Config, sdConn *dbus.Conn) func(conn *net.UnixConn) bool {
+	unit := "app-portable-" + config.Metadata.AppID + "-" + runtimeInfo.instanceID + ".service"
+	return func(conn *net.UnixConn) bool {
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			return false
+		}
+		var cred *unix.Ucred
+		var credErr error
+		err = raw.Control(func(fd uintptr) {
+			cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+		})
+		if err != nil || credErr != nil {
+			pecho("warn", "Could not get Varlink peer credentials:", err, credErr)
+			return false
+		}
+		if int(cred.Uid) != os.Getuid() {
+			pecho("warn", "Rejected Varlink peer of user " + strconv.Itoa(int(cred.Uid)))
+			return false
+		}
+		// Pins the process, so the control group read below is not one of a reused PID
+		pidfd, err := unix.PidfdOpen(int(cred.Pid), 0)
+		if err != nil {
+			pecho("warn", "Could not open Varlink peer:", err)
+			return false
+		}
+		defer unix.Close(pidfd)
+		content, err := os.ReadFile("/proc/" + strconv.Itoa(int(cred.Pid)) + "/cgroup")
+		if err != nil {
+			pecho("warn", "Could not read Varlink peer control group:", err)
+			return false
+		}
+		if unix.PidfdSendSignal(pidfd, 0, nil, 0) != nil {
+			pecho("warn", "Varlink peer exited before it could be checked")
+			return false
+		}
+		peerCgroup, err := procCgroup(string(content))
+		if err != nil {
+			pecho("warn", "Could not parse Varlink peer control group:", err)
+			return false
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+		defer cancel()
+		prop, err := sdConn.GetUnitTypePropertyContext(ctx, unit, "Service", "ControlGroup")
+		if err != nil {
+			pecho("warn", "Could not get control group of " + unit + ":", err)
+			return false
+		}
+		// Without a control group, the unit has no processes to reject
+		unitCgroup := parseStr(prop.Value.Value())
+		return len(unitCgroup) == 0 || ! pathWithin(peerCgroup, unitCgroup)
+	}
+}
+
+func listenVarlink(controller *DBusControlRequest, info *DBusInfoRequest, config Config) {
+	sockPath := varlinkSocketPath(config)
+	err := os.Remove(sockPath)
+	if err != nil && ! os.IsNotExist(err) {
+		pecho("warn", "Could not remove stale control socket:", err)
+	}
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		pecho("warn", "Could not listen on control socket:", err)
+		return
+	}
+	addStopHook(stageBusName, func() {
+		listener.Close()
+		os.Remove(sockPath)
+	})
+
+	service := varlinkService{
+		Interfaces:	map[string]string{
+			varlinkInterface:	varlinkDescription,
+		},
+		Accept:		varlinkAcceptHost(config, info.SdConn),
+		Methods:	map[string]varlinkMethod{
+			varlinkInterface + ".Stop": func(params json.RawMessage) (any, *varlinkError) {
+				if busErr := controller.Stop(); busErr != nil {
+					return nil, varlinkFailed(busErr)
+				}
+				return nil, nil
+			},
+			varlinkInterface + ".GetInfo": func(params json.RawMessage) (any, *varlinkError) {
+				reply, busErr := info.GetInfo()
+				if busErr != nil {
+					return nil, varlinkFailed(busErr)
+				}
+				return map[string]any{"info": reply}, nil
+			},
+			varlinkInterface + ".GetStats": func(params json.RawMessage) (any, *varlinkError) {
+				reply, busErr := info.GetInfo()
+				if busErr != nil {
+					return nil, varlinkFailed(busErr)
+				}
+				return map[string]any{
+					"diskUsage":	getDirSize(filepath.Join(xdgDir.dataDir, config.Metadata.StateDirectory)),
+					"info":		reply,
+				}, nil
+			},
+			varlinkInterface + ".Exec": func(params json.RawMessage) (any, *varlinkError) {
+				var req struct {
+					Arguments	[]string	`json:"arguments"`
+				}
+				if vErr := varlinkParams(params, &req, "arguments"); vErr != nil {
+					return nil, vErr
+				}
+				err := execViaHelper(config, req.Arguments)
+				if err != nil {
+					return nil, varlinkFailed(err)
+				}
+				return nil, nil
+			},
+			varlinkInterface + ".Share": func(params json.RawMessage) (any, *varlinkError) {
+				var req struct {
+					Directory	bool	`json:"directory"`
+				}
+				if vErr := varlinkParams(params, &req, "directory"); vErr != nil {
+					return nil, vErr
+				}
+				err := shareFileViaHelper(config, req.Directory)
+				if err != nil {
+					return nil, varlinkFailed(err)
+				}
+				return nil, nil
+			},
+			varlinkInterface + ".SetNetwork": func(params json.RawMessage) (any, *varlinkError) {
+				var req struct {
+					Enabled		bool	`json:"enabled"`
+				}
+				if vErr := varlinkParams(params, &req, "enabled"); vErr != nil {
+					return nil, vErr
+				}
+				if busErr := controller.SetNetwork(req.Enabled); busErr != nil {
+					return nil, varlinkFailed(busErr)
+				}
+				return nil, nil
+			},
+			varlinkInterface + ".Expose": func(params json.RawMessage) (any, *varlinkError) {
+				var req struct {
+					Host		string	`json:"host"`
+					Dest		string	`json:"dest"`
+					ReadOnly	bool	`json:"readOnly"`
+				}
+				if vErr := varlinkParams(params, &req, "host"); vErr != nil {
+					return nil, vErr
+				}
+				var flags uint32
+				if req.ReadOnly {
+					flags = flags | exposeFlagReadOnly
+				}
+				sandboxPath, busErr := controller.Expose(req.Host, req.Dest, flags)
+				if busErr != nil {
+					return nil, varlinkFailed(busErr)
+				}
+				return map[string]any{"sandboxPath": sandboxPath}, nil
+			},
+		},
+	}
+	pecho("debug", "Listening on control socket " + sockPath)
+	service.serve(listener)
+}
+
+// Starts an auxiliary process without attaching a console, output is discarded
+func execViaHelper(config Config, args []string) error {
+	conn, err := godbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	ver, err := getHelperVersion(conn, config)
+	if err != nil {
+		return err
+	}
+	// AuxStart2 is available since helper version 18
+	if ver < 18 {
+		return errors.New("Helper version " + strconv.Itoa(int(ver)) + " does not support detached execution")
+	}
+	busObj := conn.Object(config.Metadata.AppID + ".Portable.Helper", "/top/kimiblock/portable/init")
+	call := busObj.Call(
+		"top.kimiblock.Portable.Init.AuxStart2",
+		0,
+		false,
+		"",
+		false,
+		append(config.Exec.Arguments, args...),
+		map[string]string{},
+		map[string]string{},
+	)
+	if call.Err != nil {
+		return call.Err
+	}
+	var reply godbus.UnixFD
+	err = call.Store(&reply)
+	if err != nil {
+		return err
+	}
+	fd := os.NewFile(uintptr(reply), "pty")
+	go func () {
+		defer fd.Close()
+		io.Copy(io.Discard, fd)
+	} ()
+	return nil
+}
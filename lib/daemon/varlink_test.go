@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func varlinkRoundTrip(t *testing.T, conn net.Conn, reader *bufio.Reader, call varlinkCall) map[string]any {
+	encoded, err := json.Marshal(call)
+	if err != nil {
+		t.Fatal("Could not encode call:", err)
+	}
+	_, err = conn.Write(append(encoded, 0))
+	if err != nil {
+		t.Fatal("Could not write call:", err)
+	}
+	raw, err := reader.ReadBytes(0)
+	if err != nil {
+		t.Fatal("Could not read reply:", err)
+	}
+	var reply map[string]any
+	err = json.Unmarshal(raw[:len(raw) - 1], &reply)
+	if err != nil {
+		t.Fatal("Could not decode reply:", err)
+	}
+	return reply
+}
+
+func TestVarlinkService(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "control")
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+	defer listener.Close()
+
+	var stopped bool
+	service := varlinkService{
+		Interfaces:	map[string]string{
+			varlinkInterface:	varlinkDescription,
+		},
+		Methods:	map[string]varlinkMethod{
+			varlinkInterface + ".Stop": func(params json.RawMessage) (any, *varlinkError) {
+				stopped = true
+				return nil, nil
+			},
+		},
+	}
+	go service.serve(listener)
+
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatal("Could not dial:", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	reply := varlinkRoundTrip(t, conn, reader, varlinkCall{
+		Method:		"org.varlink.service.GetInterfaceDescription",
+		Parameters:	json.RawMessage(`{"interface":"` + varlinkInterface + `"}`),
+	})
+	params, _ := reply["parameters"].(map[string]any)
+	if params["description"] != varlinkDescription {
+		t.Fatal("Unexpected interface description:", reply)
+	}
+
+	reply = varlinkRoundTrip(t, conn, reader, varlinkCall{
+		Method:		varlinkInterface + ".Stop",
+	})
+	if _, hasErr := reply["error"]; hasErr || ! stopped {
+		t.Fatal("Stop was not dispatched:", reply)
+	}
+
+	reply = varlinkRoundTrip(t, conn, reader, varlinkCall{
+		Method:		varlinkInterface + ".Reboot",
+	})
+	if reply["error"] != "org.varlink.service.MethodNotFound" {
+		t.Fatal("Expected MethodNotFound, got:", reply)
+	}
+
+	reply = varlinkRoundTrip(t, conn, reader, varlinkCall{
+		Method:		"org.example.Nothing.Call",
+	})
+	if reply["error"] != "org.varlink.service.InterfaceNotFound" {
+		t.Fatal("Expected InterfaceNotFound, got:", reply)
+	}
+
+	reply = varlinkRoundTrip(t, conn, reader, varlinkCall{
+		Method:		"org.varlink.service.GetInterfaceDescription",
+		Parameters:	json.RawMessage(`{"interface":1}`),
+	})
+	if reply["error"] != "org.varlink.service.InvalidParameter" {
+		t.Fatal("Expected InvalidParameter, got:", reply)
+	}
+
+	stopped = false
+	reply = varlinkRoundTrip(t, conn, reader, varlinkCall{
+		Method:		varlinkInterface + ".Stop",
+		More:		true,
+	})
+	if reply["error"] != "org.varlink.service.InvalidParameter" || stopped {
+		t.Fatal("Expected more to be refused, got:", reply)
+	}
+}
+
+func TestProcCgroup(t *testing.T) {
+	path, err := procCgroup("0::/user.slice/user-1000.slice/user@1000.service/app.slice/app-portable-org.example.App-1.service\n")
+	if err != nil || path != "/user.slice/user-1000.slice/user@1000.service/app.slice/app-portable-org.example.App-1.service" {
+		t.Error("Unexpected control group " + path)
+	}
+	if _, err := procCgroup("12:pids:/user.slice\n"); err == nil {
+		t.Error("Accepted legacy hierarchy only")
+	}
+	unit := "/app.slice/app-portable-org.example.App-1.service"
+	if pathWithin("/app.slice/app-portable-org.example.App-1.service.bak", unit) {
+		t.Error("Sibling control group matched the unit")
+	}
+}
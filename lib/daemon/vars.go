@@ -4,6 +4,10 @@ type RUNTIME_OPT struct {
 	argStop		bool
 	applicationArgs	[]string
 	userLang	string
+	// Paths requested via --expose, routed according to instance state
+	exposeMap	map[string]string
+	// Started without a terminal, e.g. by a schedule
+	headless	bool
 }
 
 const (
@@ -16,6 +20,10 @@ type bwArgs []string
 
 type RUNTIME_PARAMS struct {
 	instanceID		string
+	// Digest of the mount layout, see layoutHash
+	layoutHash		string
+	// Device nodes in the layout, see deviceNodes
+	deviceNodes		[]string
 }
 
 type XDG_DIRS struct {
@@ -23,6 +31,7 @@ type XDG_DIRS struct {
 	confDir			string
 	cacheDir		string
 	dataDir			string
+	stateDir		string
 	home			string
 }
 
@@ -40,7 +49,7 @@ var (
 	envsFlushReady		= make(chan int8, 1)
 	// When true and present, aborts start before multiinstance detection
 	abortChan		= make(chan bool, 10)
-	gpuChan 		= make(chan []string, 1)
+	gpuChan 		= make(chan []Mount, 1)
 	busArgChan		= make(chan []string, 1)
 	nvKernelModulePath 	= []string{
 					"/sys/module/nvidia",
@@ -49,7 +58,4 @@ var (
 					"/sys/module/nvidia_uvm",
 					"/sys/module/nvidia_wmi_ec_backlight",
 				}
-
-	// Executes when the stopApp() function is called
-	stopFuncChan		= make(chan func()(), 16)
 )
\ No newline at end of file
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Exit code when the watchdog killed the application, same as systemd's SIGABRT on watchdog timeout
+const watchdogExitCode = 128 + int(syscall.SIGABRT)
+
+const (
+	watchdogNone	int32 = iota
+	watchdogStop
+	watchdogRestart
+)
+
+// Set by the ping watchdog before killing the application, consumed by startApp
+var watchdogTrip atomic.Int32
+
+// Subgroup of the application unit holding the watchdog feeder
+const watchdogCgroup = "portable-watchdog"
+
+// Environment variable starting the daemon binary as watchdog feeder, see runWatchdogFeeder
+const watchdogFeederEnv = "_portableWatchdogFeeder"
+
+var errAppGone = errors.New("no application process left")
+
+// Reads the state letter from the content of /proc/<pid>/stat
+func procStatState(stat string) (byte, error) {
+	idx := strings.LastIndexByte(stat, ')')
+	if idx < 0 || idx + 2 >= len(stat) {
+		return 0, errors.New("malformed stat line")
+	}
+	return stat[idx + 2], nil
+}
+
+// Whether the states show the application stuck, i.e. every process is in
+// uninterruptible sleep or stopped
+func statesHung(states []byte) bool {
+	if len(states) == 0 {
+		return false
+	}
+	for _, state := range states {
+		switch state {
+			case 'D', 'T', 't':
+			default:
+				return false
+		}
+	}
+	return true
+}
+
+// Bus names of the helper and the application of this instance, never those of the installed ID
+func livenessNames(config Config) (string, string) {
+	return config.Metadata.AppID + ".Portable.Helper", config.Metadata.AppID
+}
+
+// Pings a bus name of this instance
+func pingName(ctx context.Context, busConn *godbus.Conn, name string) error {
+	return busConn.Object(name, "/").CallWithContext(ctx, "org.freedesktop.DBus.Peer.Ping", godbus.FlagNoAutoStart).Err
+}
+
+// Checks that the instance is alive: its processes make progress, the helper answers
+// on its bus name and, if the application owns its own, so does the application. Only
+// names of this instance are asked, so a hung profile or default instance does not count.
+// Applications owning no bus name, or answering D-Bus from a separate thread while their
+// user interface is frozen, are only caught by the process state check
+func appLiveness(ctx context.Context, busConn *godbus.Conn, config Config, procs []string) error {
+	if len(procs) == 0 {
+		return errAppGone
+	}
+	var states []byte
+	for _, pid := range procs {
+		stat, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+		if err != nil {
+			continue
+		}
+		if state, err := procStatState(string(stat)); err == nil {
+			states = append(states, state)
+		}
+	}
+	if statesHung(states) {
+		return errors.New("application processes are stuck: " + string(states))
+	}
+	if busConn == nil {
+		return nil
+	}
+	helper, name := livenessNames(config)
+	if err := pingName(ctx, busConn, helper); err != nil {
+		return errors.New("helper does not answer on " + helper + ": " + err.Error())
+	}
+	var owned bool
+	err := busConn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.NameHasOwner", 0, name).Store(&owned)
+	if err != nil || ! owned {
+		return nil
+	}
+	if err := pingName(ctx, busConn, name); err != nil {
+		return errors.New("application does not answer on " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+// Sends WATCHDOG=1 for every line read, the first message also enables the watchdog
+func feedWatchdog(input io.Reader, usec string) error {
+	scanner := bufio.NewScanner(input)
+	state := "WATCHDOG_USEC=" + usec + "\n" + daemon.SdNotifyWatchdog
+	for scanner.Scan() {
+		sent, err := daemon.SdNotify(false, state)
+		if err != nil {
+			return err
+		} else if ! sent {
+			return errors.New("NOTIFY_SOCKET is not set")
+		}
+		state = daemon.SdNotifyWatchdog
+	}
+	return scanner.Err()
+}
+
+// Entry point of the feeder process. It lives in the application unit, as systemd only
+// accepts notifications from there, and exits once the daemon closes its input
+func runWatchdogFeeder() {
+	err := feedWatchdog(os.Stdin, os.Getenv("WATCHDOG_USEC"))
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Feeds the watchdog of the application unit while the application is alive, restarting
+// the feeder whenever the unit is started again
+func unitWatchdog(conn *dbus.Conn, config Config) {
+	if config.Processes.WatchdogSec <= 0 || config.Processes.Watchdog != "unit" {
+		return
+	}
+	busConn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("warn", "Could not connect to session bus, application bus name is not checked:", err)
+		busConn = nil
+	}
+	appUnit := appUnitName(config)
+	for ! stopRequested.Load() {
+		ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second)
+		active := unitActive(ctx, conn, appUnit)
+		cancelFunc()
+		if ! active {
+			time.Sleep(time.Second)
+			continue
+		}
+		err := feedUnit(conn, busConn, config)
+		if err != nil {
+			pecho("warn", "Watchdog disabled:", err)
+			return
+		}
+	}
+}
+
+// Runs one feeder for the current run of the application unit
+func feedUnit(conn *dbus.Conn, busConn *godbus.Conn, config Config) error {
+	interval := time.Duration(config.Processes.WatchdogSec) * time.Second
+	appUnit := appUnitName(config)
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	feeder := exec.Command(exe)
+	feeder.Env = []string{
+		watchdogFeederEnv + "=1",
+		"NOTIFY_SOCKET=" + filepath.Join(xdgDir.runtimeDir, "systemd", "notify"),
+		"WATCHDOG_USEC=" + strconv.FormatInt(interval.Microseconds(), 10),
+	}
+	input, err := feeder.StdinPipe()
+	if err != nil {
+		return err
+	}
+	err = feeder.Start()
+	if err != nil {
+		return err
+	}
+	defer feeder.Wait()
+	defer input.Close()
+	ctx, cancelFunc := context.WithTimeout(context.Background(), interval)
+	err = conn.AttachProcessesToUnit(ctx, appUnit, "/" + watchdogCgroup, []uint32{uint32(feeder.Process.Pid)})
+	cancelFunc()
+	if err != nil {
+		feeder.Process.Kill()
+		return errors.New("could not move feeder into " + appUnit + ": " + err.Error())
+	}
+	pecho("debug", "Watchdog feeder started")
+
+	// Several probes per interval, so a single slow one does not trip the watchdog
+	ticker := time.NewTicker(interval / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		if stopRequested.Load() {
+			return nil
+		}
+		ctx, cancelFunc := context.WithTimeout(context.Background(), interval / 3)
+		sample, ok := sampleUnit(ctx, conn, appUnit)
+		if ! ok {
+			cancelFunc()
+			return nil
+		}
+		err := appLiveness(ctx, busConn, config, sample.procs)
+		cancelFunc()
+		if errors.Is(err, errAppGone) {
+			// Lets the unit finish, the feeder would keep it alive otherwise
+			pecho("debug", "Application exited, stopping watchdog feeder")
+			return nil
+		} else if err != nil {
+			pecho("warn", "Not feeding watchdog:", err)
+			continue
+		}
+		_, err = input.Write([]byte("\n"))
+		if err != nil {
+			pecho("debug", "Watchdog feeder exited:", err)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Probes the application every processes.watchdogSec, and kills it after too many misses
+func pingWatchdog(conn *dbus.Conn, config Config) {
+	if config.Processes.WatchdogSec <= 0 || config.Processes.Watchdog != "ping" {
+		return
+	}
+	busConn, err := godbus.SessionBus()
+	if err != nil {
+		pecho("warn", "Could not connect to session bus, application bus name is not checked:", err)
+		busConn = nil
+	}
+	interval := time.Duration(config.Processes.WatchdogSec) * time.Second
+	appUnit := appUnitName(config)
+	var online bool
+	var misses int
+	for {
+		time.Sleep(interval)
+		if stopRequested.Load() {
+			return
+		}
+		ctx, cancelFunc := context.WithTimeout(context.Background(), interval)
+		sample, active := sampleUnit(ctx, conn, appUnit)
+		if active {
+			err = appLiveness(ctx, busConn, config, sample.procs)
+		}
+		cancelFunc()
+		if ! active || errors.Is(err, errAppGone) {
+			// Not started yet, or the application is exiting by itself
+			online = false
+			misses = 0
+			continue
+		} else if err == nil {
+			online = true
+			misses = 0
+			continue
+		} else if ! online {
+			continue
+		}
+		misses++
+		pecho("debug", "Application missed watchdog probe:", err)
+		if misses < config.Processes.WatchdogMisses {
+			continue
+		}
+		pecho(
+			"warn",
+			"Application unresponsive: missed", misses, "probes, killing it",
+		)
+		if config.Processes.WatchdogAction == "restart" {
+			watchdogTrip.Store(watchdogRestart)
+		} else {
+			watchdogTrip.Store(watchdogStop)
+		}
+		ctx, cancelFunc = context.WithTimeout(context.Background(), interval)
+		err = conn.KillUnitWithTarget(ctx, appUnit, dbus.All, int32(syscall.SIGKILL))
+		cancelFunc()
+		if err != nil {
+			pecho("warn", "Could not kill application:", err)
+			watchdogTrip.Store(watchdogNone)
+		}
+		online = false
+		misses = 0
+	}
+}
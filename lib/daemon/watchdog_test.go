@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcStatState(t *testing.T) {
+	for stat, want := range map[string]byte{
+		"1234 (app) S 1 1234 1234 0 -1":		'S',
+		"1234 (my (weird) app) D 1 1234":		'D',
+		"1234 (a) b) t 1 1234":				't',
+	} {
+		got, err := procStatState(stat)
+		if err != nil || got != want {
+			t.Error("State of \"" + stat + "\":", string(got), err)
+		}
+	}
+	for _, stat := range []string{"", "1234 (app", "1234 (app)"} {
+		if _, err := procStatState(stat); err == nil {
+			t.Error("Malformed stat accepted: \"" + stat + "\"")
+		}
+	}
+}
+
+func TestStatesHung(t *testing.T) {
+	for states, want := range map[string]bool{
+		"":		false,
+		"S":		false,
+		"D":		true,
+		"DDT":		true,
+		"DtS":		false,
+		"R":		false,
+		"TZ":		false,
+	} {
+		if got := statesHung([]byte(states)); got != want {
+			t.Error("States", states, "hung:", got)
+		}
+	}
+}
+
+func TestAppLivenessGone(t *testing.T) {
+	var config Config
+	if err := appLiveness(t.Context(), nil, config, nil); err != errAppGone {
+		t.Error("No processes reported as", err)
+	}
+}
+
+func TestFeedWatchdog(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	err = feedWatchdog(strings.NewReader("\n\n"), "5000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 256)
+	for _, want := range []string{"WATCHDOG_USEC=5000000\nWATCHDOG=1", "WATCHDOG=1"} {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Error("Sent \"" + got + "\", want \"" + want + "\"")
+		}
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := feedWatchdog(strings.NewReader("\n"), "5000000"); err == nil {
+		t.Error("Feeding without NOTIFY_SOCKET succeeded")
+	}
+}
+
+func TestLivenessNames(t *testing.T) {
+	var config Config
+	config.Metadata.AppID = "org.example.Chat"
+	config.Metadata.StateDirectory = "Chat"
+	applyProfile(&config, []string{"--profile", "work"})
+	helper, app := livenessNames(config)
+	if helper != "org.example.Chat.work.Portable.Helper" || app != "org.example.Chat.work" {
+		t.Error("Profile instance probes", helper, "and", app)
+	}
+}
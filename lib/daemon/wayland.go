@@ -18,7 +18,7 @@ func testWaylandSocket(path string) error {
 	return nil
 }
 
-func waylandDisplay(wdChan chan []string) () {
+func waylandDisplay(wdChan chan []Mount) () {
 	type wDisplay struct {
 		Path		string
 		Priority	int
@@ -97,11 +97,8 @@ func waylandDisplay(wdChan chan []string) () {
 		pecho("crit", "Could not find a useable Wayland socket")
 	}
 
-	var waylandArgs = []string{
-		"--ro-bind",
-			result.Path,
-			xdgDir.runtimeDir + "/wayland-0",
+	wdChan <- []Mount{
+		bindMount(mountRoBind, result.Path, xdgDir.runtimeDir + "/wayland-0"),
 	}
-	wdChan <- waylandArgs
 	pecho("debug", "Found Wayland socket: " + result.Path)
 }
\ No newline at end of file